@@ -0,0 +1,62 @@
+package gaedispemu
+
+import "strings"
+
+const appspotDomain = "appspot.com"
+
+// appspotHost is a hostname of App Engine which targets the specific service (and version)
+type appspotHost struct {
+	Version string
+	Service string
+}
+
+// parseAppspotHost parses the hostname like below:
+//
+//	SERVICE-dot-PROJECT.REGION.r.appspot.com
+//	VERSION-dot-SERVICE-dot-PROJECT.REGION.r.appspot.com
+//	INSTANCE-dot-VERSION-dot-SERVICE-dot-PROJECT.REGION.r.appspot.com
+//	SERVICE.PROJECT.appspot.com
+//	VERSION.SERVICE.PROJECT.appspot.com
+//
+// and returns nil if the hostname does not target any service of the project.
+func parseAppspotHost(host, projectID, regionID string) *appspotHost {
+	if projectID == "" {
+		return nil
+	}
+
+	var domains []string
+	if regionID != "" {
+		domains = append(domains, projectID+"."+regionID+".r."+appspotDomain)
+	}
+	domains = append(domains, projectID+"."+appspotDomain)
+
+	for _, domain := range domains {
+		if prefix := strings.TrimSuffix(host, "-dot-"+domain); prefix != host {
+			return newAppspotHost(strings.Split(prefix, "-dot-"))
+		}
+		if prefix := strings.TrimSuffix(host, "."+domain); prefix != host {
+			return newAppspotHost(strings.Split(prefix, "."))
+		}
+	}
+	return nil
+}
+
+func newAppspotHost(labels []string) *appspotHost {
+	for _, label := range labels {
+		if label == "" {
+			return nil
+		}
+	}
+
+	switch len(labels) {
+	case 1:
+		return &appspotHost{Service: labels[0]}
+	case 2:
+		return &appspotHost{Version: labels[0], Service: labels[1]}
+	case 3:
+		// the instance label is not meaningful for the emulator
+		return &appspotHost{Version: labels[1], Service: labels[2]}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,96 @@
+package gaedispemu
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseAppspotHost(t *testing.T) {
+	cases := []struct {
+		Host, ProjectID, RegionID string
+		Expected                  *appspotHost
+	}{
+		{
+			Host:      "api-dot-myproject.uc.r.appspot.com",
+			ProjectID: "myproject",
+			RegionID:  "uc",
+			Expected:  &appspotHost{Service: "api"},
+		},
+		{
+			Host:      "v1-dot-api-dot-myproject.uc.r.appspot.com",
+			ProjectID: "myproject",
+			RegionID:  "uc",
+			Expected:  &appspotHost{Version: "v1", Service: "api"},
+		},
+		{
+			Host:      "i1-dot-v1-dot-api-dot-myproject.uc.r.appspot.com",
+			ProjectID: "myproject",
+			RegionID:  "uc",
+			Expected:  &appspotHost{Version: "v1", Service: "api"},
+		},
+		{
+			Host:      "api-dot-myproject.appspot.com",
+			ProjectID: "myproject",
+			RegionID:  "uc",
+			Expected:  &appspotHost{Service: "api"},
+		},
+		{
+			Host:      "api-dot-myproject.appspot.com",
+			ProjectID: "myproject",
+			Expected:  &appspotHost{Service: "api"},
+		},
+		{
+			Host:      "api.myproject.appspot.com",
+			ProjectID: "myproject",
+			Expected:  &appspotHost{Service: "api"},
+		},
+		{
+			Host:      "v1.api.myproject.appspot.com",
+			ProjectID: "myproject",
+			Expected:  &appspotHost{Version: "v1", Service: "api"},
+		},
+		{
+			Host:      "myproject.uc.r.appspot.com",
+			ProjectID: "myproject",
+			RegionID:  "uc",
+			Expected:  nil,
+		},
+		{
+			Host:      "myproject.appspot.com",
+			ProjectID: "myproject",
+			Expected:  nil,
+		},
+		{
+			Host:      "api-dot-otherproject.appspot.com",
+			ProjectID: "myproject",
+			Expected:  nil,
+		},
+		{
+			Host:      "api-dot-myproject.uc.r.appspot.com",
+			ProjectID: "myproject",
+			RegionID:  "ew",
+			Expected:  nil,
+		},
+		{
+			Host:      "-dot-myproject.appspot.com",
+			ProjectID: "myproject",
+			Expected:  nil,
+		},
+		{
+			Host:      "a-dot-b-dot-c-dot-d-dot-myproject.appspot.com",
+			ProjectID: "myproject",
+			Expected:  nil,
+		},
+		{
+			Host:     "api-dot-myproject.appspot.com",
+			Expected: nil,
+		},
+	}
+	for _, c := range cases {
+		got := parseAppspotHost(c.Host, c.ProjectID, c.RegionID)
+		if diff := cmp.Diff(c.Expected, got); diff != "" {
+			t.Errorf("`%s` is failed: diff=%s", c.Host, diff)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package gaedispemu
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// CACertificateFileName is the file name of the certificate of the local CA
+	CACertificateFileName = "ca.pem"
+	// CAKeyFileName is the file name of the private key of the local CA
+	CAKeyFileName = "ca-key.pem"
+)
+
+const (
+	caValidity = 10 * 365 * 24 * time.Hour
+	// some clients reject the server certificates valid for longer than 825 days
+	leafValidity = 365 * 24 * time.Hour
+)
+
+// CertificateAuthority is a local CA which issues the certificates for the hosts on the fly
+type CertificateAuthority struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+
+	mu    sync.Mutex
+	certs map[string]*tls.Certificate
+}
+
+// NewCertificateAuthority creates a new CA in memory
+func NewCertificateAuthority() (*CertificateAuthority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"gae-dispatcher-emulator"}, CommonName: "gae-dispatcher-emulator local CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CertificateAuthority{cert: cert, key: key, certs: map[string]*tls.Certificate{}}, nil
+}
+
+// LoadOrCreateCertificateAuthority loads the CA from the directory,
+// or creates it and saves to the directory if it does not exist yet.
+func LoadOrCreateCertificateAuthority(dir string) (*CertificateAuthority, error) {
+	certFile := filepath.Join(dir, CACertificateFileName)
+	keyFile := filepath.Join(dir, CAKeyFileName)
+
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err == nil {
+		cert, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+		key, ok := pair.PrivateKey.(crypto.Signer)
+		if !ok || !cert.IsCA {
+			return nil, fmt.Errorf("%s is not a certificate of CA", certFile)
+		}
+		return &CertificateAuthority{cert: cert, key: key, certs: map[string]*tls.Certificate{}}, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	ca, err := NewCertificateAuthority()
+	if err != nil {
+		return nil, err
+	}
+	if err := ca.save(certFile, keyFile); err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+func (ca *CertificateAuthority) save(certFile, keyFile string) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(ca.key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(certFile), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, ca.CertificatePEM(), 0644)
+}
+
+// CertificatePEM returns the certificate of the CA in PEM to trust it
+func (ca *CertificateAuthority) CertificatePEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+}
+
+// Certificate returns the certificate for the host (a hostname or an IP address) issued by the CA.
+// The issued certificates are cached until they expire.
+func (ca *CertificateAuthority) Certificate(host string) (*tls.Certificate, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if cert, ok := ca.certs[host]; ok && time.Now().Before(cert.Leaf.NotAfter) {
+		return cert, nil
+	}
+
+	cert, err := ca.issue(host)
+	if err != nil {
+		return nil, err
+	}
+	ca.certs[host] = cert
+	return cert, nil
+}
+
+func (ca *CertificateAuthority) issue(host string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"gae-dispatcher-emulator"}, CommonName: host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+	if template.NotAfter.After(ca.cert.NotAfter) {
+		template.NotAfter = ca.cert.NotAfter
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der, ca.cert.Raw}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// TLSConfig returns the config to serve HTTPS by the certificates issued for the server name (SNI) of the clients.
+// allowHost rejects the handshake for the host not to issue the certificates for arbitrary hosts (nil allows all),
+// and the clients without SNI (e.g. requesting to the IP address) get the certificate for the listening IP address.
+func (ca *CertificateAuthority) TLSConfig(allowHost func(host string) bool) *tls.Config {
+	return &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			host := hello.ServerName
+			if host == "" {
+				host = "localhost"
+				if hello.Conn != nil {
+					if ip, _, err := net.SplitHostPort(hello.Conn.LocalAddr().String()); err == nil {
+						host = ip
+					}
+				}
+			}
+			if allowHost != nil && !allowHost(host) {
+				return nil, fmt.Errorf("no certificate for the host: %s", host)
+			}
+			return ca.Certificate(host)
+		},
+	}
+}
+
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
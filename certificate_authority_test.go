@@ -0,0 +1,116 @@
+package gaedispemu
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCertificateAuthority(t *testing.T) {
+	ca, err := NewCertificateAuthority()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca.CertificatePEM()) {
+		t.Fatal("should be a PEM certificate")
+	}
+
+	for _, host := range []string{"example.com", "Mobile.Example.COM.", "127.0.0.1", "::1"} {
+		cert, err := ca.Certificate(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opts := x509.VerifyOptions{DNSName: host, Roots: roots}
+		if _, err := cert.Leaf.Verify(opts); err != nil {
+			t.Errorf("%s: %v", host, err)
+		}
+	}
+
+	first, err := ca.Certificate("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ca.Certificate("EXAMPLE.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Error("should cache the certificate")
+	}
+}
+
+func TestLoadOrCreateCertificateAuthority(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gaedispemu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	caDir := filepath.Join(dir, "ca")
+	created, err := LoadOrCreateCertificateAuthority(caDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat, err := os.Stat(filepath.Join(caDir, CAKeyFileName)); err != nil {
+		t.Fatal(err)
+	} else if stat.Mode().Perm() != 0600 {
+		t.Errorf("the private key should not be readable by others: %v", stat.Mode())
+	}
+
+	loaded, err := LoadOrCreateCertificateAuthority(caDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(created.CertificatePEM(), loaded.CertificatePEM()) {
+		t.Error("should load the created CA")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(caDir, CAKeyFileName), []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadOrCreateCertificateAuthority(caDir); err == nil {
+		t.Error("should be error")
+	}
+}
+
+func TestCertificateAuthorityTLSConfig(t *testing.T) {
+	ca, err := NewCertificateAuthority()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// httptest.Server.StartTLS serves its own certificate for the clients without SNI
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Listener = tls.NewListener(server.Listener, ca.TLSConfig(func(host string) bool {
+		return host != "unknown.example.com"
+	}))
+	server.Config.ErrorLog = discardLogger
+	server.Start()
+	defer server.Close()
+	serverURL := strings.Replace(server.URL, "http://", "https://", 1)
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.CertificatePEM())
+	for serverName, ok := range map[string]bool{"example.com": true, "": true, "unknown.example.com": false} {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: serverName}}}
+		res, err := client.Get(serverURL)
+		if ok && err != nil {
+			t.Errorf("%q: %v", serverName, err)
+		} else if !ok && err == nil {
+			t.Errorf("%q: should be error", serverName)
+		}
+		if res != nil {
+			res.Body.Close()
+		}
+	}
+}
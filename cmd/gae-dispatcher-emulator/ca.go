@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os"
+
+	"github.com/jessevdk/go-flags"
+)
+
+type caCommand struct {
+	opts *options
+}
+
+var _ flags.Commander = (*caCommand)(nil)
+
+func (c *caCommand) Execute(args []string) error {
+	ca, err := c.opts.loadCertificateAuthority()
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stdout.Write(ca.CertificatePEM())
+	return err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+	gaedispemu "github.com/karupanerura/gae-dispatcher-emulator"
+)
+
+type convertCommand struct {
+	OutputFile string `short:"o" long:"output" description:"output file (the format is determined by the extension, stdout is default)"`
+	Format     string `long:"to" description:"output format" choice:"yaml" choice:"xml" choice:"json"`
+	Args       struct {
+		ConfigFile string `positional-arg-name:"FILE" description:"dispatch.xml, dispatch.yaml or dispatch rules JSON" required:"1"`
+	} `positional-args:"yes"`
+}
+
+var _ flags.Commander = (*convertCommand)(nil)
+
+func (c *convertCommand) Execute(args []string) error {
+	format := c.Format
+	if format == "" && c.OutputFile != "" {
+		format = strings.TrimPrefix(filepath.Ext(c.OutputFile), ".")
+	}
+	if format == "" {
+		return &flags.Error{Type: flags.ErrRequired, Message: "the output format is not specified by `--to' or `-o, --output'"}
+	}
+
+	writer := newConfigWriter(format)
+	if writer == nil {
+		return fmt.Errorf("Failed to determine config type for %q", c.OutputFile)
+	}
+
+	loader := newConfigLoader(c.Args.ConfigFile)
+	if loader == nil {
+		return fmt.Errorf("Failed to determine config type for %q", c.Args.ConfigFile)
+	}
+
+	config, err := loader.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("Failed to load config: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := writer.WriteConfig(&buf, config); err != nil {
+		return fmt.Errorf("Failed to write config: %v", err)
+	}
+
+	if c.OutputFile == "" {
+		_, err = os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	return os.WriteFile(c.OutputFile, buf.Bytes(), 0644)
+}
+
+func newConfigWriter(format string) gaedispemu.ConfigWriter {
+	switch format {
+	case "xml":
+		return gaedispemu.NewXMLConfigWriter()
+	case "yaml", "yml":
+		return gaedispemu.NewYAMLConfigWriter()
+	case "json":
+		return gaedispemu.NewJSONConfigWriter()
+	}
+
+	return nil
+}
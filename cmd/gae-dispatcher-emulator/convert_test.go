@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	gaedispemu "github.com/karupanerura/gae-dispatcher-emulator"
+)
+
+func TestConvertCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gaedispemu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, fileName := range []string{"dispatch.yaml", "dispatch.yml"} {
+		outputFile := filepath.Join(dir, fileName)
+		c := &convertCommand{OutputFile: outputFile}
+		c.Args.ConfigFile = "../../testdata/dispatch.xml"
+		if err := c.Execute(nil); err != nil {
+			t.Errorf("%s: %v", fileName, err)
+			continue
+		}
+
+		config, err := gaedispemu.NewYAMLConfigLoader(outputFile).LoadConfig()
+		if err != nil {
+			t.Errorf("%s: should be written as YAML: %v", fileName, err)
+		} else if config.Len() != 4 {
+			t.Errorf("%s: unexpected rules: %d", fileName, config.Len())
+		}
+	}
+
+	c := &convertCommand{OutputFile: filepath.Join(dir, "dispatch.txt")}
+	c.Args.ConfigFile = "../../testdata/dispatch.xml"
+	if err := c.Execute(nil); err == nil {
+		t.Error("should be failed for unknown extension")
+	}
+}
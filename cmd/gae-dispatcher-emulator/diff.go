@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jessevdk/go-flags"
+	gaedispemu "github.com/karupanerura/gae-dispatcher-emulator"
+)
+
+type diffCommand struct {
+	opts *options
+	Args struct {
+		OldConfigFile string `positional-arg-name:"OLD" description:"dispatch.xml, dispatch.yaml or dispatch rules JSON before the change" required:"1"`
+		NewConfigFile string `positional-arg-name:"NEW" description:"dispatch.xml, dispatch.yaml or dispatch rules JSON after the change" required:"1"`
+	} `positional-args:"yes"`
+}
+
+var _ flags.Commander = (*diffCommand)(nil)
+
+func (c *diffCommand) Execute(args []string) error {
+	oldConfig, err := loadConfigFile(c.Args.OldConfigFile)
+	if err != nil {
+		return err
+	}
+	newConfig, err := loadConfigFile(c.Args.NewConfigFile)
+	if err != nil {
+		return err
+	}
+
+	diff, err := gaedispemu.DiffConfigs(oldConfig, newConfig, c.opts.getDispatcherOptions()...)
+	if err != nil {
+		return err
+	}
+	if !diff.HasChanges() {
+		fmt.Println("No differences")
+		return nil
+	}
+
+	fmt.Printf("--- %s\n", c.Args.OldConfigFile)
+	fmt.Printf("+++ %s\n", c.Args.NewConfigFile)
+	if len(diff.Rules) != 0 {
+		fmt.Println("rules:")
+		for _, change := range diff.Rules {
+			fmt.Printf("  %s\n", change)
+		}
+	}
+	if len(diff.Behavior) != 0 {
+		fmt.Println("behavior:")
+		for _, change := range diff.Behavior {
+			fmt.Printf("  %s\n", change)
+		}
+	}
+	return fmt.Errorf("%d rule changes and %d behavior changes found", len(diff.Rules), len(diff.Behavior))
+}
+
+func loadConfigFile(configFile string) (*gaedispemu.Config, error) {
+	loader := newConfigLoader(configFile)
+	if loader == nil {
+		return nil, fmt.Errorf("Failed to determine config type for %q", configFile)
+	}
+
+	config, err := loader.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load config: %s: %v", configFile, err)
+	}
+	return config, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jessevdk/go-flags"
+	gaedispemu "github.com/karupanerura/gae-dispatcher-emulator"
+)
+
+type lintCommand struct {
+	Args struct {
+		ConfigFiles []string `positional-arg-name:"FILE" description:"dispatch.xml, dispatch.yaml or dispatch rules JSON" required:"1"`
+	} `positional-args:"yes"`
+}
+
+var _ flags.Commander = (*lintCommand)(nil)
+
+func (c *lintCommand) Execute(args []string) error {
+	problems := 0
+	for _, configFile := range c.Args.ConfigFiles {
+		loader := newConfigLoader(configFile)
+		if loader == nil {
+			return fmt.Errorf("Failed to determine config type for %q", configFile)
+		}
+
+		config, err := loader.LoadConfig()
+		if err != nil {
+			return fmt.Errorf("Failed to load config: %v", err)
+		}
+
+		issues := gaedispemu.LintConfig(config)
+		for _, issue := range issues {
+			rule := config.Rules[issue.Index]
+			fmt.Printf("%s: %s (url: %s, service: %s)\n", configFile, issue, rule, rule.ServiceName)
+		}
+		if len(issues) == 0 {
+			fmt.Printf("%s: OK\n", configFile)
+		}
+		problems += len(issues)
+	}
+
+	if problems != 0 {
+		return fmt.Errorf("%d problems found", problems)
+	}
+	return nil
+}
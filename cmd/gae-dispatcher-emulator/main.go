@@ -1,23 +1,52 @@
 // Usage:
-//   gae-dispatcher-emulator [OPTIONS]
+//   gae-dispatcher-emulator [OPTIONS] [command]
 //
 // Application Options:
-//   -c, --config=  dispatch.xml or dispatch.yaml
-//   -s, --service= service map (e.g. --service default:localhost:8081 --service admin:localhost:8082)
-//   -l, --listen=  listening host:port (localhost:3000 is default) (default: localhost:3000)
-//   -v, --verbose  verbose output for proxy request
+//   -c, --config=        dispatch.xml, dispatch.yaml or dispatch rules JSON
+//   -s, --service=       service map (e.g. --service default:localhost:8081 --service admin:localhost:8082)
+//       --services-file= services map file in YAML, JSON or TOML (overridden by GAEDISPEMU_SERVICE_<NAME> env and --service)
+//       --discover=      discover the services from app.yaml files under the directory (overridden by --services-file)
+//       --procfile=      launch the services by the commands in Procfile with PORT, GAE_SERVICE and GAE_VERSION env
+//       --health-check=  check the health of the services by the path (e.g. /_ah/health) unless the services file gives it
+//       --wait-healthy   wait until all services are healthy before listening
+//       --admin=         listening host:port for the admin endpoint (GET /health shows the health of the services)
+//   -l, --listen=        listening host:port (localhost:3000 is default) (default: localhost:3000)
+//       --http2          serve cleartext HTTP/2 with prior knowledge (h2c) in addition to HTTP/1.1 (HTTPS always serves HTTP/2)
+//       --tls-cert=      certificate file to serve HTTPS (with --tls-key)
+//       --tls-key=       private key file to serve HTTPS (with --tls-cert)
+//       --tls-auto       serve HTTPS by the certificates issued on the fly by the local CA for the hosts in the dispatch rules (see ca command)
+//       --tls-ca-dir=    directory of the local CA for --tls-auto (created in the user config directory by default)
+//       --host-header=   read the host for dispatching from the header instead of Host (e.g. X-Forwarded-Host)
+//       --no-fallback    respond 404 for the request matched no dispatch rules instead of routing it to the default service
+//       --project=       project ID to route by the hostname (e.g. SERVICE-dot-PROJECT.REGION.r.appspot.com)
+//       --region=        region ID to route by the hostname (e.g. uc)
+//   -w, --watch          reload the config when the file is modified (it is also reloaded on SIGHUP)
+//   -v, --verbose        verbose output for proxy request
 //
 // Help Options:
-//   -h, --help     Show this help message
+//   -h, --help           Show this help message
+//
+// Available commands:
+//   ca        print the certificate of the local CA for --tls-auto
+//   convert   convert dispatch files between dispatch.yaml, dispatch.xml and dispatch rules JSON
+//   diff      show the rule and behavior changes between dispatch files
+//   lint      find unreachable rules in dispatch files
+//   route     explain which rule and service a URL hits
+//   test      check the expected routing of URLs
+//   validate  validate dispatch files
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/jessevdk/go-flags"
 	gaedispemu "github.com/karupanerura/gae-dispatcher-emulator"
@@ -25,11 +54,27 @@ import (
 )
 
 type options struct {
-	ConfigFile  string   `short:"c" long:"config" description:"dispatch.xml or dispatch.yaml" required:"true"`
-	Services    []string `short:"s" long:"service" description:"service map (e.g. --service default:localhost:8081 --service admin:localhost:8082)" required:"true"`
-	ListenAddr  string   `short:"l" long:"listen" description:"listening host:port (localhost:3000 is default)" default:"localhost:3000"`
-	Verbose     bool     `short:"v" long:"verbose" description:"verbose output for proxy request"`
-	ShowVersion func()   `long:"version" description:"show version"`
+	ConfigFile   string   `short:"c" long:"config" description:"dispatch.xml, dispatch.yaml or dispatch rules JSON"`
+	Services     []string `short:"s" long:"service" description:"service map (e.g. --service default:localhost:8081 --service admin:localhost:8082)"`
+	ServicesFile string   `long:"services-file" description:"services map file in YAML, JSON or TOML (overridden by GAEDISPEMU_SERVICE_<NAME> env and --service)"`
+	DiscoverDir  string   `long:"discover" description:"discover the services from app.yaml files under the directory (overridden by --services-file)"`
+	Procfile     string   `long:"procfile" description:"launch the services by the commands in Procfile with PORT, GAE_SERVICE and GAE_VERSION env"`
+	HealthCheck  string   `long:"health-check" description:"check the health of the services by the path (e.g. /_ah/health) unless the services file gives it"`
+	WaitHealthy  bool     `long:"wait-healthy" description:"wait until all services are healthy before listening"`
+	AdminAddr    string   `long:"admin" description:"listening host:port for the admin endpoint (GET /health shows the health of the services)"`
+	ListenAddr   string   `short:"l" long:"listen" description:"listening host:port (localhost:3000 is default)" default:"localhost:3000"`
+	HTTP2        bool     `long:"http2" description:"serve cleartext HTTP/2 with prior knowledge (h2c) in addition to HTTP/1.1 (HTTPS always serves HTTP/2)"`
+	TLSCert      string   `long:"tls-cert" description:"certificate file to serve HTTPS (with --tls-key)"`
+	TLSKey       string   `long:"tls-key" description:"private key file to serve HTTPS (with --tls-cert)"`
+	TLSAuto      bool     `long:"tls-auto" description:"serve HTTPS by the certificates issued on the fly by the local CA for the hosts in the dispatch rules (see ca command)"`
+	TLSCADir     string   `long:"tls-ca-dir" description:"directory of the local CA for --tls-auto (created in the user config directory by default)"`
+	HostHeader   string   `long:"host-header" description:"read the host for dispatching from the header instead of Host (e.g. X-Forwarded-Host)"`
+	NoFallback   bool     `long:"no-fallback" description:"respond 404 for the request matched no dispatch rules instead of routing it to the default service"`
+	ProjectID    string   `long:"project" description:"project ID to route by the hostname (e.g. SERVICE-dot-PROJECT.REGION.r.appspot.com)"`
+	RegionID     string   `long:"region" description:"region ID to route by the hostname (e.g. uc)"`
+	Watch        bool     `short:"w" long:"watch" description:"reload the config when the file is modified (it is also reloaded on SIGHUP)"`
+	Verbose      bool     `short:"v" long:"verbose" description:"verbose output for proxy request"`
+	ShowVersion  func()   `long:"version" description:"show version"`
 }
 
 func main() {
@@ -40,27 +85,111 @@ func main() {
 		},
 	}
 
-	_, err := flags.ParseArgs(&opts, os.Args)
+	parser := flags.NewParser(&opts, flags.HelpFlag|flags.PassDoubleDash)
+	parser.SubcommandsOptional = true
+	parser.CommandHandler = func(command flags.Commander, args []string) error {
+		if command == nil {
+			return opts.serve()
+		}
+		return command.Execute(args)
+	}
+	parser.AddCommand("ca", "print the certificate of the local CA for --tls-auto", "Print the certificate of the local CA for --tls-auto in PEM to trust it. The CA is created if it does not exist yet.", &caCommand{opts: &opts})
+	parser.AddCommand("convert", "convert dispatch files between dispatch.yaml, dispatch.xml and dispatch rules JSON", "Convert the dispatch file to dispatch.yaml, dispatch.xml or dispatch rules JSON keeping the rule order and the comments (JSON has no comments).", &convertCommand{})
+	parser.AddCommand("diff", "show the rule and behavior changes between dispatch files", "Show the added, removed, moved and changed rules, and the sample requests routed to another service.", &diffCommand{opts: &opts})
+	parser.AddCommand("lint", "find unreachable rules in dispatch files", "Find the rules which can never match because an earlier rule shadows them, and the duplicated rules.", &lintCommand{})
+	parser.AddCommand("route", "explain which rule and service a URL hits", "Explain which rule and service the URLs hit with the dispatch file given by --config.", &routeCommand{opts: &opts})
+	parser.AddCommand("test", "check the expected routing of URLs", "Check that the URLs in the routing case files are routed to the expected services with the dispatch file given by --config.", &testCommand{opts: &opts})
+	parser.AddCommand("validate", "validate dispatch files", "Validate dispatch files with the limits of App Engine and report all problems.", &validateCommand{})
+
+	_, err := parser.ParseArgs(os.Args[1:])
 	if ferr, ok := err.(*flags.Error); ok && ferr.Type == flags.ErrHelp {
+		fmt.Println(ferr.Message)
 		return
-	} else if err != nil {
+	} else if ok {
 		log.Printf("Failed to parse args: %v", err)
 		os.Exit(1)
+	} else if err != nil {
+		log.Printf("%v", err)
+		os.Exit(1)
 	}
+}
 
-	handler, err := createProxyHandler(&opts)
+// serve runs the proxy server when no commands are given
+func (o *options) serve() error {
+	if o.ConfigFile == "" {
+		return &flags.Error{Type: flags.ErrRequired, Message: "the required flag `-c, --config' was not specified"}
+	}
+	if err := o.checkTLSOptions(); err != nil {
+		return err
+	}
+
+	services, err := o.getServicsMap()
 	if err != nil {
-		log.Printf("%v", err)
-		os.Exit(1)
+		return err
 	}
 
-	if opts.Verbose {
-		http.DefaultTransport = loghttp.DefaultTransport
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	supervisor := gaedispemu.NewSupervisor(services)
+	if supervisor.Len() != 0 {
+		log.Printf("Launching %d services", supervisor.Len())
+		if err := supervisor.Start(ctx); err != nil {
+			return err
+		}
+		defer supervisor.Stop()
+	}
+
+	checker := o.createHealthChecker(services)
+	if checker.Len() != 0 {
+		go checker.Run(ctx)
+		if o.WaitHealthy {
+			log.Printf("Waiting for %d services to be healthy", checker.Len())
+			if err := checker.WaitHealthy(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	if o.AdminAddr != "" {
+		go o.serveAdmin(ctx, checker)
 	}
 
-	server := opts.getServer(handler)
-	log.Printf("Listen on %s", opts.ListenAddr)
-	log.Fatal(server.ListenAndServe())
+	dispatcher, err := createDispatcher(o, services)
+	if err != nil {
+		return err
+	}
+
+	go reloadOnSignal(dispatcher)
+	if o.Watch {
+		go dispatcher.Watch(ctx, o.ConfigFile, time.Second, logReload)
+	}
+
+	handler := createProxyHandler(ctx, o, dispatcher, checker)
+
+	server := o.getServer(handler)
+	server.TLSConfig, err = o.getTLSConfig(dispatcher)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if o.useTLS() {
+		log.Printf("Listen on %s (HTTPS)", o.ListenAddr)
+		err = server.ListenAndServeTLS(o.TLSCert, o.TLSKey)
+	} else {
+		log.Printf("Listen on %s", o.ListenAddr)
+		err = server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		return err
+	}
+	log.Printf("Shutting down")
+	return nil
 }
 
 type loggingErrorReporter struct{}
@@ -69,46 +198,235 @@ func (r loggingErrorReporter) ReportError(err error) {
 	log.Printf("ERROR: %v", err)
 }
 
-func createProxyHandler(opts *options) (http.Handler, error) {
+func createDispatcher(opts *options, services map[string]*gaedispemu.Service) (*gaedispemu.ReloadableDispatcher, error) {
 	loader := opts.getConfigLoader()
 	if loader == nil {
 		return nil, fmt.Errorf("Failed to determine config type for %q", opts.ConfigFile)
 	}
 
-	config, err := loader.LoadConfig()
+	if len(services) == 0 {
+		return nil, &flags.Error{Type: flags.ErrRequired, Message: "the services should be given by `-s, --service', `--services-file', `--discover', `--procfile' or GAEDISPEMU_SERVICE_<NAME>"}
+	}
+	if opts.DiscoverDir != "" {
+		config, err := loader.LoadConfig()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load config: %v", err)
+		}
+		if err := opts.checkDiscoveredServices(config, services); err != nil {
+			return nil, err
+		}
+	}
+	for _, service := range services {
+		if service.Origin == nil && service.Split == nil {
+			log.Printf("[WARN] No origin for the service %s, give it by --service or the port annotation in app.yaml", service.Name)
+		}
+	}
+
+	dispatcher, err := gaedispemu.NewReloadableDispatcher(loader, services, opts.getDispatcherOptions()...)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to load config: %v", err)
 	}
+	warnRuleCount(dispatcher.Config())
+
+	return dispatcher, nil
+}
+
+func (o options) getDispatcherOptions() []gaedispemu.DispatcherOption {
+	var opts []gaedispemu.DispatcherOption
+	if o.NoFallback {
+		opts = append(opts, gaedispemu.WithoutFallback())
+	}
+	if o.ProjectID != "" {
+		opts = append(opts, gaedispemu.WithProject(o.ProjectID, o.RegionID))
+	}
+	return opts
+}
+
+func warnRuleCount(config *gaedispemu.Config) {
 	if config.Len() > 20 {
 		log.Printf("[WARN] dispatch rules over than 20 rules (%d rules found)\n", config.Len())
 	}
+}
 
-	services, err := opts.getServicsMap()
+func reloadOnSignal(dispatcher *gaedispemu.ReloadableDispatcher) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		logReload(dispatcher.Reload())
+	}
+}
+
+func logReload(prev, next *gaedispemu.Config, err error) {
 	if err != nil {
-		return nil, err
+		log.Printf("[WARN] Failed to reload config, keep using the previous rules: %v", err)
+		return
 	}
 
-	dispatcher, err := gaedispemu.NewDispatcher(services, config)
+	log.Printf("Reloaded config: %d rules -> %d rules", prev.Len(), next.Len())
+	diff, err := gaedispemu.DiffConfigs(prev, next)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to mapping backend: %v", err)
+		log.Printf("[WARN] Failed to compare the rules: %v", err)
+	} else {
+		for _, change := range diff.Rules {
+			log.Printf("  %s", change)
+		}
 	}
+	warnRuleCount(next)
+}
 
+func createProxyHandler(ctx context.Context, opts *options, dispatcher gaedispemu.Dispatcher, checker *gaedispemu.HealthChecker) http.Handler {
 	reporter := loggingErrorReporter{}
-	return gaedispemu.NewProxyHandlerWithReporter(dispatcher, reporter), nil
+	handlerOpts := []gaedispemu.ProxyHandlerOption{
+		// the upgraded connections (e.g. WebSocket) are closed on shutdown
+		gaedispemu.WithUpgradeContext(ctx),
+		gaedispemu.WithUpgradeHandler(logUpgrade),
+	}
+	if opts.HostHeader != "" {
+		handlerOpts = append(handlerOpts, gaedispemu.WithHostHeader(opts.HostHeader))
+	}
+	if checker.Len() != 0 {
+		handlerOpts = append(handlerOpts, gaedispemu.WithHealthChecker(checker))
+	}
+	if opts.Verbose {
+		// the transports of the services with http2 or tls are also logged
+		handlerOpts = append(handlerOpts, gaedispemu.WithTransportWrapper(func(t http.RoundTripper) http.RoundTripper {
+			return &loghttp.Transport{Transport: t}
+		}))
+	}
+	return gaedispemu.NewProxyHandlerWithReporter(dispatcher, reporter, handlerOpts...)
+}
+
+func logUpgrade(conn *gaedispemu.UpgradedConnection) {
+	service := conn.Service
+	if conn.Version != "" {
+		service += " (version: " + conn.Version + ")"
+	}
+
+	if conn.Closed.IsZero() {
+		log.Printf("Upgraded the connection from %s to %s for %s %s", conn.RemoteAddr, conn.Protocol, service, conn.Path)
+	} else {
+		log.Printf("Closed the %s connection from %s for %s %s after %s (sent: %d bytes, received: %d bytes)", conn.Protocol, conn.RemoteAddr, service, conn.Path, conn.Closed.Sub(conn.Opened).Round(time.Millisecond), conn.Sent, conn.Received)
+	}
+}
+
+// createHealthChecker gives the health check by --health-check to the services without it
+func (o options) createHealthChecker(services map[string]*gaedispemu.Service) *gaedispemu.HealthChecker {
+	if o.HealthCheck != "" {
+		for _, service := range services {
+			// the versions of the split services are not checked
+			if service.HealthCheck == nil && !(service.Origin == nil && service.Split != nil) {
+				service.HealthCheck = &gaedispemu.HealthCheck{Path: o.HealthCheck}
+			}
+		}
+	}
+
+	return gaedispemu.NewHealthChecker(services, gaedispemu.WithHealthChangeHandler(logHealthChange))
+}
+
+func logHealthChange(state gaedispemu.HealthState) {
+	if state.Healthy {
+		log.Printf("The service %s (%s) is healthy", state.Service, state.Origin)
+	} else {
+		log.Printf("[WARN] The service %s (%s) is unhealthy: %s", state.Service, state.Origin, state.Error)
+	}
+}
+
+func (o options) serveAdmin(ctx context.Context, checker *gaedispemu.HealthChecker) {
+	mux := http.NewServeMux()
+	mux.Handle("/health", checker)
+
+	server := &http.Server{
+		Addr:     o.AdminAddr,
+		Handler:  mux,
+		ErrorLog: log.New(os.Stderr, "", log.LstdFlags),
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Printf("Admin endpoint on %s", o.AdminAddr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Printf("[WARN] Failed to serve admin endpoint: %v", err)
+	}
 }
 
 func (o options) getConfigLoader() gaedispemu.ConfigLoader {
-	if strings.HasSuffix(o.ConfigFile, ".xml") {
-		return gaedispemu.NewXMLConfigLoader(o.ConfigFile)
-	} else if strings.HasSuffix(o.ConfigFile, ".yaml") {
-		return gaedispemu.NewYAMLConfigLoader(o.ConfigFile)
+	return newConfigLoader(o.ConfigFile)
+}
+
+func newConfigLoader(filePath string) gaedispemu.ConfigLoader {
+	if strings.HasSuffix(filePath, ".xml") {
+		return gaedispemu.NewXMLConfigLoader(filePath)
+	} else if strings.HasSuffix(filePath, ".yaml") {
+		return gaedispemu.NewYAMLConfigLoader(filePath)
+	} else if strings.HasSuffix(filePath, ".json") {
+		return gaedispemu.NewJSONConfigLoader(filePath)
 	}
 
 	return nil
 }
 
+// servicesEnvPrefix is the prefix of the environment variables to map the services
+// (e.g. GAEDISPEMU_SERVICE_MOBILE_FRONTEND=localhost:8082 for mobile-frontend service)
+const servicesEnvPrefix = "GAEDISPEMU_SERVICE_"
+
+// getServicsMap merges the discovered services, the services file, the Procfile, the environment variables and the --service flags in order.
+// The later one overrides the origins (or the command) of the service and keeps the other settings.
 func (o options) getServicsMap() (map[string]*gaedispemu.Service, error) {
-	m := make(map[string]*gaedispemu.Service, len(o.Services))
+	m := map[string]*gaedispemu.Service{}
+	if o.DiscoverDir != "" {
+		services, err := gaedispemu.DiscoverServices(o.DiscoverDir)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to discover services: %v", err)
+		}
+		m = services
+	}
+
+	if o.ServicesFile != "" {
+		services, err := gaedispemu.LoadServicesFile(o.ServicesFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load services file: %v", err)
+		}
+		for name, service := range services {
+			if discovered, ok := m[name]; ok {
+				service.Dir = discovered.Dir
+			}
+			m[name] = service
+		}
+	}
+
+	if o.Procfile != "" {
+		commands, err := gaedispemu.LoadProcfile(o.Procfile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load Procfile: %v", err)
+		}
+		for name, command := range commands {
+			service := &gaedispemu.Service{Name: name}
+			if s, ok := m[name]; ok {
+				copied := *s
+				service = &copied
+			}
+			service.Command = command
+			m[name] = service
+		}
+	}
+
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, servicesEnvPrefix) {
+			continue
+		}
+
+		index := strings.Index(env, "=")
+		name := strings.ToLower(strings.Replace(env[len(servicesEnvPrefix):index], "_", "-", -1))
+		origins, err := gaedispemu.ParseOrigins(env[index+1:])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid service map format: %s (%v)", env, err)
+		}
+		setServiceOrigins(m, name, origins)
+	}
+
+	seen := make(map[string]bool, len(o.Services))
 	for _, service := range o.Services {
 		index := strings.Index(service, ":")
 		if index == -1 {
@@ -116,34 +434,54 @@ func (o options) getServicsMap() (map[string]*gaedispemu.Service, error) {
 		}
 
 		name := service[:index]
-		if _, ok := m[name]; ok {
+		if seen[name] {
 			return nil, fmt.Errorf("Duplicated service name: %s", name)
 		}
+		seen[name] = true
 
-		origin, err := parseOrigin(service[index+1:])
+		origins, err := gaedispemu.ParseOrigins(service[index+1:])
 		if err != nil {
 			return nil, fmt.Errorf("Invalid service map format: %s (%v)", service, err)
 		}
-		m[name] = &gaedispemu.Service{
-			Name:   name,
-			Origin: origin,
-		}
+		setServiceOrigins(m, name, origins)
 	}
 	return m, nil
 }
 
-func parseOrigin(s string) (*url.URL, error) {
-	if strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") {
-		return url.Parse(s)
+// checkDiscoveredServices returns an error if the rules reference the services which have no app.yaml
+func (o options) checkDiscoveredServices(config *gaedispemu.Config, services map[string]*gaedispemu.Service) error {
+	if o.DiscoverDir == "" {
+		return nil
 	}
 
-	return url.Parse("http://" + s)
+	if names := gaedispemu.UndefinedServices(config, services); len(names) != 0 {
+		return fmt.Errorf("No %s found under %s for the services in %s: %s", gaedispemu.AppYAMLFileName, o.DiscoverDir, o.ConfigFile, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// setServiceOrigins sets the origins (e.g. "localhost:8081,localhost:8082" for several instances) to the service
+func setServiceOrigins(m map[string]*gaedispemu.Service, name string, origins []*url.URL) {
+	service := &gaedispemu.Service{Name: name}
+	if s, ok := m[name]; ok {
+		copied := *s
+		service = &copied
+	}
+	service.SetOrigins(origins)
+	m[name] = service
 }
 
 func (o options) getServer(h http.Handler) *http.Server {
-	return &http.Server{
+	server := &http.Server{
 		Addr:     o.ListenAddr,
 		Handler:  h,
 		ErrorLog: log.New(os.Stderr, "", log.LstdFlags),
 	}
+	if o.HTTP2 {
+		server.Protocols = new(http.Protocols)
+		server.Protocols.SetHTTP1(true)
+		server.Protocols.SetHTTP2(true)
+		server.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return server
 }
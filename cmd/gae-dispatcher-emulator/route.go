@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/jessevdk/go-flags"
+	gaedispemu "github.com/karupanerura/gae-dispatcher-emulator"
+)
+
+type routeCommand struct {
+	opts *options
+	Args struct {
+		URLs []string `positional-arg-name:"URL" description:"URL to route (e.g. https://example.com/mobile/foo)" required:"1"`
+	} `positional-args:"yes"`
+}
+
+var _ flags.Commander = (*routeCommand)(nil)
+
+func (c *routeCommand) Execute(args []string) error {
+	if c.opts.ConfigFile == "" {
+		return &flags.Error{Type: flags.ErrRequired, Message: "the required flag `-c, --config' was not specified"}
+	}
+
+	loader := c.opts.getConfigLoader()
+	if loader == nil {
+		return fmt.Errorf("Failed to determine config type for %q", c.opts.ConfigFile)
+	}
+
+	config, err := loader.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("Failed to load config: %v", err)
+	}
+
+	// the backends are optional to explain the routing
+	services, err := c.opts.getServicsMap()
+	if err != nil {
+		return err
+	}
+	if err := c.opts.checkDiscoveredServices(config, services); err != nil {
+		return err
+	}
+	if len(services) == 0 {
+		services = gaedispemu.PlaceholderServices(config)
+	}
+
+	dispatcher, err := gaedispemu.NewDispatcher(services, config, c.opts.getDispatcherOptions()...)
+	if err != nil {
+		return fmt.Errorf("Failed to mapping backend: %v", err)
+	}
+
+	for _, rawURL := range c.Args.URLs {
+		host, path, err := gaedispemu.SplitURL(rawURL)
+		if err != nil {
+			return fmt.Errorf("Invalid URL: %s (%v)", rawURL, err)
+		}
+
+		result := gaedispemu.Resolve(dispatcher, host, path)
+		fmt.Println(rawURL)
+		switch result.Reason {
+		case gaedispemu.DispatchReasonRule:
+			fmt.Printf("  rule:    #%d %s\n", result.RuleIndex, result.Pattern)
+		case gaedispemu.DispatchReasonHostname:
+			fmt.Printf("  rule:    (none) the hostname targets the service\n")
+		case gaedispemu.DispatchReasonFallback:
+			fmt.Printf("  rule:    (none) no rules matched, so it falls back to the %s service\n", gaedispemu.DefaultServiceName)
+		case gaedispemu.DispatchReasonNoMatch:
+			if c.opts.NoFallback {
+				fmt.Printf("  rule:    (none) no rules matched and the fallback is disabled\n")
+			} else {
+				fmt.Printf("  rule:    (none) no rules matched and the %s service is not defined\n", gaedispemu.DefaultServiceName)
+			}
+		}
+		if result.Service == nil {
+			fmt.Printf("  service: (none)\n")
+			continue
+		}
+
+		fmt.Printf("  service: %s\n", result.Service.Name)
+		if result.Version != "" {
+			fmt.Printf("  version: %s\n", result.Version)
+		} else if result.Service.Split != nil {
+			printTrafficSplit(result.Service)
+			continue
+		}
+		for _, origin := range result.Service.Instances() {
+			fmt.Printf("  backend: %s\n", origin)
+		}
+	}
+	return nil
+}
+
+// printTrafficSplit prints the versions the traffic is split to with the backends
+func printTrafficSplit(service *gaedispemu.Service) {
+	names := make([]string, 0, len(service.Split.Allocations))
+	for name := range service.Split.Allocations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("  split:   by %s\n", service.Split.ShardBy)
+	for _, name := range names {
+		fmt.Printf("  version: %s (%g%%)\n", name, service.Split.Allocations[name]*100)
+		for _, origin := range service.Versions[name].Instances() {
+			fmt.Printf("    backend: %s\n", origin)
+		}
+	}
+}
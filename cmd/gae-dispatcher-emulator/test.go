@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jessevdk/go-flags"
+	gaedispemu "github.com/karupanerura/gae-dispatcher-emulator"
+)
+
+type testCommand struct {
+	opts *options
+	Args struct {
+		CaseFiles []string `positional-arg-name:"FILE" description:"routing cases (e.g. dispatch_test.yaml)" required:"1"`
+	} `positional-args:"yes"`
+}
+
+var _ flags.Commander = (*testCommand)(nil)
+
+func (c *testCommand) Execute(args []string) error {
+	if c.opts.ConfigFile == "" {
+		return &flags.Error{Type: flags.ErrRequired, Message: "the required flag `-c, --config' was not specified"}
+	}
+
+	config, err := loadConfigFile(c.opts.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	// the backends are optional to test the routing
+	services, err := c.opts.getServicsMap()
+	if err != nil {
+		return err
+	}
+	if err := c.opts.checkDiscoveredServices(config, services); err != nil {
+		return err
+	}
+	if len(services) == 0 {
+		services = gaedispemu.PlaceholderServices(config)
+	}
+
+	dispatcher, err := gaedispemu.NewDispatcher(services, config, c.opts.getDispatcherOptions()...)
+	if err != nil {
+		return fmt.Errorf("Failed to mapping backend: %v", err)
+	}
+
+	passed, failed := 0, 0
+	for _, caseFile := range c.Args.CaseFiles {
+		cases, err := gaedispemu.LoadRoutingCases(caseFile)
+		if err != nil {
+			return fmt.Errorf("Failed to load routing cases: %s: %v", caseFile, err)
+		}
+
+		for _, result := range gaedispemu.RunRoutingCases(dispatcher, cases) {
+			if result.Passed() {
+				fmt.Printf("PASS %s\n", result)
+				passed++
+			} else {
+				fmt.Printf("FAIL %s:%d: %s\n", caseFile, result.Case.Line, result)
+				failed++
+			}
+		}
+	}
+
+	fmt.Printf("%d passed, %d failed\n", passed, failed)
+	if failed != 0 {
+		return fmt.Errorf("%d cases failed", failed)
+	}
+	return nil
+}
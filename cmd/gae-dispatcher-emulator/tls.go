@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gaedispemu "github.com/karupanerura/gae-dispatcher-emulator"
+)
+
+func (o options) useTLS() bool {
+	return o.TLSCert != "" || o.TLSKey != "" || o.TLSAuto
+}
+
+func (o options) checkTLSOptions() error {
+	if (o.TLSCert == "") != (o.TLSKey == "") {
+		return fmt.Errorf("--tls-cert and --tls-key should be given together")
+	}
+	if o.TLSAuto && o.TLSCert != "" {
+		return fmt.Errorf("--tls-auto cannot be used with --tls-cert and --tls-key")
+	}
+	return nil
+}
+
+// getCADir returns the directory of the local CA (the user config directory is default)
+func (o options) getCADir() (string, error) {
+	if o.TLSCADir != "" {
+		return o.TLSCADir, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("Failed to determine the directory of the local CA, give it by --tls-ca-dir: %v", err)
+	}
+	return filepath.Join(dir, "gae-dispatcher-emulator"), nil
+}
+
+func (o options) loadCertificateAuthority() (*gaedispemu.CertificateAuthority, error) {
+	dir, err := o.getCADir()
+	if err != nil {
+		return nil, err
+	}
+
+	ca, err := gaedispemu.LoadOrCreateCertificateAuthority(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load the local CA: %v", err)
+	}
+	return ca, nil
+}
+
+// getTLSConfig returns the config to issue the certificates by the local CA for --tls-auto,
+// or nil for --tls-cert and --tls-key which are given to ListenAndServeTLS.
+func (o options) getTLSConfig(dispatcher *gaedispemu.ReloadableDispatcher) (*tls.Config, error) {
+	if !o.TLSAuto {
+		return nil, nil
+	}
+
+	ca, err := o.loadCertificateAuthority()
+	if err != nil {
+		return nil, err
+	}
+	return ca.TLSConfig(o.allowTLSHost(dispatcher.Config)), nil
+}
+
+// allowTLSHost allows the hosts named in the current dispatch rules, the hostnames of the project and localhost
+// not to issue the certificates for arbitrary hosts.
+// The wildcard rules allow only the subdomains of "*.example.com", and "*" allows no extra hosts.
+func (o options) allowTLSHost(currentConfig func() *gaedispemu.Config) func(host string) bool {
+	var domains []string
+	if o.ProjectID != "" {
+		project := strings.ToLower(o.ProjectID)
+		if o.RegionID != "" {
+			domains = append(domains, project+"."+strings.ToLower(o.RegionID)+".r.appspot.com")
+		}
+		domains = append(domains, project+".appspot.com")
+	}
+
+	return func(host string) bool {
+		host = strings.ToLower(host)
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") || net.ParseIP(host) != nil {
+			return true
+		}
+		for _, domain := range domains {
+			if host == domain || strings.HasSuffix(host, "."+domain) || strings.HasSuffix(host, "-dot-"+domain) {
+				return true
+			}
+		}
+
+		for _, rule := range currentConfig().Rules {
+			m, ok := rule.HostPathMatcher.(gaedispemu.PatternMatcher)
+			if !ok {
+				continue
+			}
+
+			part := m.Host()
+			value := strings.ToLower(part.Value)
+			switch part.Wildcard {
+			case gaedispemu.WildcardNone:
+				if host == value {
+					return true
+				}
+			case gaedispemu.WildcardSuffix:
+				if strings.HasPrefix(value, ".") && len(host) > len(value) && strings.HasSuffix(host, value) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
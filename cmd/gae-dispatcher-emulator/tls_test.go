@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	gaedispemu "github.com/karupanerura/gae-dispatcher-emulator"
+)
+
+func TestAllowTLSHost(t *testing.T) {
+	var rules []gaedispemu.ConfigRule
+	for _, pattern := range []string{"*/favicon.ico", "static.example.com/*", "*.example.net/*", "*example.org/*"} {
+		m, err := gaedispemu.CompileHostPathMatcher(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, gaedispemu.ConfigRule{ServiceName: "default", HostPathMatcher: m})
+	}
+	config := &gaedispemu.Config{Rules: rules}
+
+	o := options{ProjectID: "simple-sample", RegionID: "uc"}
+	allowHost := o.allowTLSHost(func() *gaedispemu.Config { return config })
+	cases := map[string]bool{
+		"localhost":                              true,
+		"app.localhost":                          true,
+		"127.0.0.1":                              true,
+		"simple-sample.appspot.com":              true,
+		"v1-dot-simple-sample.appspot.com":       true,
+		"api.simple-sample.uc.r.appspot.com":     true,
+		"static.example.com":                     true,
+		"Static.Example.com":                     true,
+		"www.example.net":                        true,
+		"a.b.example.net":                        true,
+		"example.net":                            false,
+		"example.org":                            false,
+		"www.example.org":                        false,
+		"evil.example":                           false,
+		"www.example.com":                        false,
+		"other-sample.appspot.com":               false,
+		"simple-sample.appspot.com.evil.example": false,
+	}
+	for host, expected := range cases {
+		if allowed := allowHost(host); allowed != expected {
+			t.Errorf("%s: expected %v but got %v", host, expected, allowed)
+		}
+	}
+}
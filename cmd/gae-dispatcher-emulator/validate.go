@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jessevdk/go-flags"
+	gaedispemu "github.com/karupanerura/gae-dispatcher-emulator"
+)
+
+type validateCommand struct {
+	Args struct {
+		ConfigFiles []string `positional-arg-name:"FILE" description:"dispatch.xml, dispatch.yaml or dispatch rules JSON" required:"1"`
+	} `positional-args:"yes"`
+}
+
+var _ flags.Commander = (*validateCommand)(nil)
+
+func (c *validateCommand) Execute(args []string) error {
+	problems := 0
+	for _, configFile := range c.Args.ConfigFiles {
+		validator, ok := newConfigLoader(configFile).(gaedispemu.ConfigValidator)
+		if !ok {
+			return fmt.Errorf("Failed to determine config type for %q", configFile)
+		}
+
+		err := validator.ValidateConfig()
+		if errs, ok := err.(gaedispemu.ValidationErrors); ok {
+			for _, e := range errs {
+				fmt.Printf("%s:%d: %s\n", configFile, e.Line, e.Message)
+			}
+			problems += len(errs)
+		} else if err != nil {
+			fmt.Printf("%s: %v\n", configFile, err)
+			problems++
+		} else {
+			fmt.Printf("%s: OK\n", configFile)
+		}
+	}
+
+	if problems != 0 {
+		return fmt.Errorf("%d problems found", problems)
+	}
+	return nil
+}
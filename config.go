@@ -1,8 +1,13 @@
 package gaedispemu
 
+import "fmt"
+
 // Config is an abstruct configuration for GAE dispatch services.
 type Config struct {
 	Rules []ConfigRule
+
+	// Comment is the comment at the head of the config file
+	Comment string
 }
 
 // Len is length of the config.
@@ -14,4 +19,15 @@ func (c Config) Len() int {
 type ConfigRule struct {
 	ServiceName string
 	HostPathMatcher
+
+	// Comment is the comment for the rule in the config file
+	Comment string
+}
+
+// patternMatcher returns the matcher of the rule if it knows the original URL pattern
+func (r *ConfigRule) patternMatcher() (PatternMatcher, error) {
+	if m, ok := r.HostPathMatcher.(PatternMatcher); ok {
+		return m, nil
+	}
+	return nil, fmt.Errorf("Unknown URL pattern of the rule for service: %s", r.ServiceName)
 }
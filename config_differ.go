@@ -0,0 +1,261 @@
+package gaedispemu
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RuleChangeKind is a kind of the change of a dispatch rule
+type RuleChangeKind string
+
+const (
+	// RuleAdded means the rule exists only in the new config
+	RuleAdded RuleChangeKind = "added"
+	// RuleRemoved means the rule exists only in the old config
+	RuleRemoved RuleChangeKind = "removed"
+	// RuleMoved means the order of the rule is changed relative to the other rules
+	RuleMoved RuleChangeKind = "moved"
+	// RuleServiceChanged means the rule routes to another service
+	RuleServiceChanged RuleChangeKind = "service changed"
+)
+
+// RuleChange is a structural change of a dispatch rule.
+// The rules are identified by the URL pattern.
+type RuleChange struct {
+	Kind    RuleChangeKind
+	Pattern string
+	// OldIndex is the index of the rule in the old config (-1 if added)
+	OldIndex int
+	// NewIndex is the index of the rule in the new config (-1 if removed)
+	NewIndex   int
+	OldService string
+	NewService string
+}
+
+func (c *RuleChange) String() string {
+	switch c.Kind {
+	case RuleAdded:
+		return fmt.Sprintf("+ rule #%d %s (service: %s)", c.NewIndex, c.Pattern, c.NewService)
+	case RuleRemoved:
+		return fmt.Sprintf("- rule #%d %s (service: %s)", c.OldIndex, c.Pattern, c.OldService)
+	case RuleMoved:
+		return fmt.Sprintf("~ rule #%d %s (service: %s) is moved to #%d", c.OldIndex, c.Pattern, c.OldService, c.NewIndex)
+	default:
+		return fmt.Sprintf("~ rule #%d %s (service: %s) -> #%d (service: %s)", c.OldIndex, c.Pattern, c.OldService, c.NewIndex, c.NewService)
+	}
+}
+
+// BehaviorChange is a sample request routed to another service
+type BehaviorChange struct {
+	Host string
+	Path string
+	Old  *DispatchResult
+	New  *DispatchResult
+}
+
+func (c *BehaviorChange) String() string {
+	return fmt.Sprintf("%s%s: %s %s -> %s %s", c.Host, c.Path, serviceNameOf(c.Old), c.Old, serviceNameOf(c.New), c.New)
+}
+
+func serviceNameOf(result *DispatchResult) string {
+	if result.Service == nil {
+		return "(none)"
+	}
+	return result.Service.Name
+}
+
+// ConfigDiff is the difference between two configs
+type ConfigDiff struct {
+	Rules    []*RuleChange
+	Behavior []*BehaviorChange
+}
+
+// HasChanges returns true if any rules or behaviors are changed
+func (d *ConfigDiff) HasChanges() bool {
+	return len(d.Rules) != 0 || len(d.Behavior) != 0
+}
+
+// DiffConfigs compares the rules of the configs, and dispatches the sample requests derived from the both rules
+// to find the requests routed to another service.
+// Only the first sample is reported for each pair of the old and new routing to keep the result readable.
+func DiffConfigs(oldConfig, newConfig *Config, opts ...DispatcherOption) (*ConfigDiff, error) {
+	for _, config := range []*Config{oldConfig, newConfig} {
+		for i := range config.Rules {
+			if _, err := config.Rules[i].patternMatcher(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	services := PlaceholderServices(oldConfig, newConfig)
+	oldDispatcher, err := NewDispatcher(services, oldConfig, opts...)
+	if err != nil {
+		return nil, err
+	}
+	newDispatcher, err := NewDispatcher(services, newConfig, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &ConfigDiff{Rules: diffRules(oldConfig, newConfig)}
+	reported := map[string]bool{}
+	hosts, paths := sampleHostPaths(oldConfig, newConfig)
+	for _, host := range hosts {
+		for _, path := range paths {
+			oldResult := Resolve(oldDispatcher, host, path)
+			newResult := Resolve(newDispatcher, host, path)
+			if serviceNameOf(oldResult) == serviceNameOf(newResult) {
+				continue
+			}
+
+			key := serviceNameOf(oldResult) + " " + oldResult.String() + "\x00" + serviceNameOf(newResult) + " " + newResult.String()
+			if reported[key] {
+				continue
+			}
+			reported[key] = true
+
+			diff.Behavior = append(diff.Behavior, &BehaviorChange{Host: host, Path: path, Old: oldResult, New: newResult})
+		}
+	}
+	return diff, nil
+}
+
+// patternOf returns the matcher of the rule checked by DiffConfigs
+func patternOf(rule ConfigRule) PatternMatcher {
+	return rule.HostPathMatcher.(PatternMatcher)
+}
+
+// ruleKeys identifies the rule by the URL pattern and the occurrence of the same pattern
+func ruleKeys(config *Config) []string {
+	keys := make([]string, len(config.Rules))
+	seen := map[string]int{}
+	for i, rule := range config.Rules {
+		pattern := patternOf(rule).String()
+		keys[i] = fmt.Sprintf("%s\x00%d", pattern, seen[pattern])
+		seen[pattern]++
+	}
+	return keys
+}
+
+func diffRules(oldConfig, newConfig *Config) []*RuleChange {
+	oldKeys, newKeys := ruleKeys(oldConfig), ruleKeys(newConfig)
+	oldIndex := map[string]int{}
+	for i, key := range oldKeys {
+		oldIndex[key] = i
+	}
+	newIndex := map[string]int{}
+	for i, key := range newKeys {
+		newIndex[key] = i
+	}
+
+	var changes []*RuleChange
+	var common []int // the indexes in the old config of the rules in the both configs by the new order
+	for i, key := range oldKeys {
+		if _, ok := newIndex[key]; !ok {
+			rule := oldConfig.Rules[i]
+			changes = append(changes, &RuleChange{Kind: RuleRemoved, Pattern: patternOf(rule).String(), OldIndex: i, NewIndex: -1, OldService: rule.ServiceName})
+		}
+	}
+	for i, key := range newKeys {
+		j, ok := oldIndex[key]
+		if !ok {
+			rule := newConfig.Rules[i]
+			changes = append(changes, &RuleChange{Kind: RuleAdded, Pattern: patternOf(rule).String(), OldIndex: -1, NewIndex: i, NewService: rule.ServiceName})
+			continue
+		}
+		common = append(common, j)
+	}
+
+	// the rules out of the longest increasing subsequence are moved
+	stay := longestIncreasingSubsequence(common)
+	for _, j := range common {
+		i := newIndex[oldKeys[j]]
+		oldRule, newRule := oldConfig.Rules[j], newConfig.Rules[i]
+		change := &RuleChange{Pattern: patternOf(oldRule).String(), OldIndex: j, NewIndex: i, OldService: oldRule.ServiceName, NewService: newRule.ServiceName}
+		if oldRule.ServiceName != newRule.ServiceName {
+			change.Kind = RuleServiceChanged
+		} else if !stay[j] {
+			change.Kind = RuleMoved
+		} else {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// longestIncreasingSubsequence returns the set of the values in a longest increasing subsequence
+func longestIncreasingSubsequence(values []int) map[int]bool {
+	length := make([]int, len(values))
+	prev := make([]int, len(values))
+	last := -1
+	for i := range values {
+		length[i], prev[i] = 1, -1
+		for j := 0; j < i; j++ {
+			if values[j] < values[i] && length[j]+1 > length[i] {
+				length[i], prev[i] = length[j]+1, j
+			}
+		}
+		if last == -1 || length[i] > length[last] {
+			last = i
+		}
+	}
+
+	result := map[int]bool{}
+	for i := last; i != -1; i = prev[i] {
+		result[values[i]] = true
+	}
+	return result
+}
+
+// sampleHostPaths derives the sample hosts and paths which hit each rule and the edges of it
+func sampleHostPaths(configs ...*Config) (hosts, paths []string) {
+	seenHosts := map[string]bool{}
+	addHost := func(host string) {
+		if !seenHosts[host] {
+			seenHosts[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	seenPaths := map[string]bool{}
+	addPath := func(path string) {
+		if !seenPaths[path] {
+			seenPaths[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	addHost("example.com")
+	addPath("/")
+	addPath("/sample")
+	for _, config := range configs {
+		for _, rule := range config.Rules {
+			host := patternOf(rule).Host()
+			switch host.Wildcard {
+			case WildcardNone:
+				addHost(host.Value)
+			case WildcardSuffix:
+				if strings.HasPrefix(host.Value, ".") {
+					addHost("sample" + host.Value)
+					addHost(strings.TrimPrefix(host.Value, "."))
+				} else {
+					addHost("sample." + host.Value)
+					addHost(host.Value)
+				}
+			}
+
+			path := patternOf(rule).Path()
+			switch path.Wildcard {
+			case WildcardNone:
+				addPath(path.Value)
+			case WildcardPrefix:
+				addPath(path.Value)
+				addPath(path.Value + "sample")
+				if trimmed := strings.TrimSuffix(path.Value, "/"); trimmed != "" {
+					addPath(trimmed)
+				}
+			}
+		}
+	}
+	return hosts, paths
+}
@@ -0,0 +1,86 @@
+package gaedispemu
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func newTestConfig(rules ...string) *Config {
+	config := &Config{}
+	for i := 0; i < len(rules); i += 2 {
+		config.Rules = append(config.Rules, ConfigRule{
+			HostPathMatcher: mustCompileHostPathMatcher(rules[i]),
+			ServiceName:     rules[i+1],
+		})
+	}
+	return config
+}
+
+func TestDiffConfigs(t *testing.T) {
+	oldConfig := newTestConfig(
+		"*/favicon.ico", "default",
+		"*/mobile/*", "mobile-frontend",
+		"*/work/*", "static-backend",
+		"admin.example.com/*", "admin",
+	)
+	newConfig := newTestConfig(
+		"*/work/*", "static-backend",
+		"*/favicon.ico", "default",
+		"*/mobile/*", "mobile",
+		"*.example.com/*", "admin",
+	)
+
+	diff, err := DiffConfigs(oldConfig, newConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.HasChanges() {
+		t.Error("should have changes")
+	}
+
+	rules := make([]string, len(diff.Rules))
+	for i, change := range diff.Rules {
+		rules[i] = change.String()
+	}
+	expectedRules := []string{
+		"- rule #3 admin.example.com/* (service: admin)",
+		"+ rule #3 *.example.com/* (service: admin)",
+		"~ rule #2 */work/* (service: static-backend) is moved to #0",
+		"~ rule #1 */mobile/* (service: mobile-frontend) -> #2 (service: mobile)",
+	}
+	if d := cmp.Diff(expectedRules, rules); d != "" {
+		t.Errorf("unexpected rule changes: %s", d)
+	}
+
+	behavior := make([]string, len(diff.Behavior))
+	for i, change := range diff.Behavior {
+		behavior[i] = change.String()
+	}
+	expectedBehavior := []string{
+		"example.com/mobile/: mobile-frontend #1 */mobile/* -> mobile #2 */mobile/*",
+		"sample.example.com/: default (fallback) -> admin #3 *.example.com/*",
+	}
+	if d := cmp.Diff(expectedBehavior, behavior); d != "" {
+		t.Errorf("unexpected behavior changes: %s", d)
+	}
+}
+
+func TestDiffConfigsNoChanges(t *testing.T) {
+	config, err := NewYAMLConfigLoader("./testdata/dispatch.yaml").LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	xmlConfig, err := NewXMLConfigLoader("./testdata/dispatch.xml").LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := DiffConfigs(config, xmlConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.HasChanges() {
+		t.Errorf("should not have changes: %v %v", diff.Rules, diff.Behavior)
+	}
+}
@@ -0,0 +1,55 @@
+package gaedispemu
+
+import "fmt"
+
+// LintIssue is a rule which can never match because an earlier rule covers it
+type LintIssue struct {
+	// Index is the index of the unreachable rule
+	Index int
+	// CoveredBy is the index of the earlier rule which matches everything the rule matches
+	CoveredBy int
+	// Duplicated is true if the both rules match exactly the same requests
+	Duplicated bool
+}
+
+func (i *LintIssue) String() string {
+	if i.Duplicated {
+		return fmt.Sprintf("rule #%d is a duplicate of rule #%d", i.Index, i.CoveredBy)
+	}
+	return fmt.Sprintf("rule #%d is unreachable because rule #%d shadows it", i.Index, i.CoveredBy)
+}
+
+// LintConfig finds the rules which can never match because the rules are evaluated in order
+// and an earlier rule matches everything the rule matches.
+func LintConfig(config *Config) []*LintIssue {
+	var issues []*LintIssue
+	for i, rule := range config.Rules {
+		for j, earlier := range config.Rules[:i] {
+			if !coversHostPath(earlier.HostPathMatcher, rule.HostPathMatcher) {
+				continue
+			}
+
+			issues = append(issues, &LintIssue{
+				Index:      i,
+				CoveredBy:  j,
+				Duplicated: coversHostPath(rule.HostPathMatcher, earlier.HostPathMatcher),
+			})
+			break
+		}
+	}
+	return issues
+}
+
+// coversHostPath returns true if a matches all of the requests b matches.
+// The matchers which do not know the URL pattern are never covered.
+func coversHostPath(a, b HostPathMatcher) bool {
+	pa, ok := a.(PatternMatcher)
+	if !ok {
+		return false
+	}
+	pb, ok := b.(PatternMatcher)
+	if !ok {
+		return false
+	}
+	return pa.Host().Covers(pb.Host()) && pa.Path().Covers(pb.Path())
+}
@@ -0,0 +1,89 @@
+package gaedispemu
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLintConfig(t *testing.T) {
+	newConfig := func(patterns ...string) *Config {
+		config := &Config{}
+		for _, pattern := range patterns {
+			config.Rules = append(config.Rules, ConfigRule{
+				ServiceName:     "default",
+				HostPathMatcher: mustCompileHostPathMatcher(pattern),
+			})
+		}
+		return config
+	}
+
+	cases := []struct {
+		Name     string
+		Config   *Config
+		Expected []*LintIssue
+	}{
+		{
+			Name:     "NoIssues",
+			Config:   newConfig("*/favicon.ico", "simple-sample.appspot.com/", "*/mobile/*", "*/work/*"),
+			Expected: nil,
+		},
+		{
+			Name:   "CatchAll",
+			Config: newConfig("*/*", "*/mobile/*", "example.com/"),
+			Expected: []*LintIssue{
+				{Index: 1, CoveredBy: 0},
+				{Index: 2, CoveredBy: 0},
+			},
+		},
+		{
+			Name:   "Duplicated",
+			Config: newConfig("*/mobile/*", "*/work/*", "*/mobile/*", "*/", "*/*"),
+			Expected: []*LintIssue{
+				{Index: 2, CoveredBy: 0, Duplicated: true},
+				{Index: 4, CoveredBy: 3, Duplicated: true},
+			},
+		},
+		{
+			Name:   "PathPrefix",
+			Config: newConfig("*/mobile*", "*/mobile/*", "*/mobile/index.html", "*/mob*"),
+			Expected: []*LintIssue{
+				{Index: 1, CoveredBy: 0},
+				{Index: 2, CoveredBy: 0},
+			},
+		},
+		{
+			Name:   "HostSuffix",
+			Config: newConfig("*.example.com/*", "foo.example.com/*", "*.foo.example.com/*", "example.com/*", "*example.com/*"),
+			Expected: []*LintIssue{
+				{Index: 1, CoveredBy: 0},
+				{Index: 2, CoveredBy: 0},
+			},
+		},
+		{
+			Name:   "HostAndPath",
+			Config: newConfig("example.com/mobile/*", "*/mobile/*", "example.com/mobile/foo", "*/mobile/foo"),
+			Expected: []*LintIssue{
+				{Index: 2, CoveredBy: 0},
+				{Index: 3, CoveredBy: 1},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			issues := LintConfig(c.Config)
+			if diff := cmp.Diff(c.Expected, issues); diff != "" {
+				t.Errorf("unexpected issues: %s", diff)
+			}
+		})
+	}
+}
+
+func TestLintIssue(t *testing.T) {
+	if s := (&LintIssue{Index: 2, CoveredBy: 0, Duplicated: true}).String(); s != "rule #2 is a duplicate of rule #0" {
+		t.Errorf("unexpected message: %s", s)
+	}
+	if s := (&LintIssue{Index: 2, CoveredBy: 1}).String(); s != "rule #2 is unreachable because rule #1 shadows it" {
+		t.Errorf("unexpected message: %s", s)
+	}
+}
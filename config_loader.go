@@ -1,6 +1,53 @@
 package gaedispemu
 
-// ConfigLoader is an interface to load dispatch.xml or dispatch.yaml
+import "strings"
+
+// ConfigLoader is an interface to load dispatch.xml, dispatch.yaml or dispatch rules JSON
 type ConfigLoader interface {
 	LoadConfig() (*Config, error)
 }
+
+// rawConfigRule is a dispatch rule which is not compiled yet
+type rawConfigRule struct {
+	Line        int
+	URL         string
+	ServiceName string
+	Comment     string
+}
+
+// rawConfig is a config which is not compiled yet
+type rawConfig struct {
+	Rules   []rawConfigRule
+	Comment string
+
+	// Problems are non-fatal problems found on parsing (e.g. unknown keys)
+	Problems ValidationErrors
+}
+
+func (c *rawConfig) compile() (*Config, error) {
+	rules := make([]ConfigRule, len(c.Rules))
+	for i, entry := range c.Rules {
+		hostPathMatcher, err := CompileHostPathMatcher(entry.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		rules[i] = ConfigRule{
+			ServiceName:     entry.ServiceName,
+			HostPathMatcher: hostPathMatcher,
+			Comment:         entry.Comment,
+		}
+	}
+	return &Config{Rules: rules, Comment: c.Comment}, nil
+}
+
+// joinComments joins the non-empty comments with newline
+func joinComments(comments ...string) string {
+	var lines []string
+	for _, comment := range comments {
+		if comment != "" {
+			lines = append(lines, comment)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,89 @@
+package gaedispemu
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// MaxConfigRules is the maximum number of dispatch rules App Engine accepts
+	MaxConfigRules = 20
+
+	// MaxURLPatternLength is the maximum length of URL pattern App Engine accepts
+	MaxURLPatternLength = 100
+)
+
+// ConfigValidator is an interface to validate dispatch.xml, dispatch.yaml or dispatch rules JSON
+type ConfigValidator interface {
+	// ValidateConfig returns ValidationErrors if it finds any problems in the config.
+	ValidateConfig() error
+}
+
+// ValidationError is a problem of the config
+type ValidationError struct {
+	// Line is the line number of the problem in the config file (0 if unknown)
+	Line    int
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Line == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// ValidationErrors is a list of the problems of the config
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (c *rawConfig) validate() error {
+	errs := append(ValidationErrors{}, c.Problems...)
+	if len(c.Rules) > MaxConfigRules {
+		errs = append(errs, &ValidationError{
+			Line:    c.Rules[MaxConfigRules].Line,
+			Message: fmt.Sprintf("too many dispatch rules: %d rules found (up to %d rules)", len(c.Rules), MaxConfigRules),
+		})
+	}
+
+	definedAt := map[string]int{}
+	for _, rule := range c.Rules {
+		if rule.URL == "" {
+			errs = append(errs, &ValidationError{Line: rule.Line, Message: "url is required"})
+		} else if len(rule.URL) > MaxURLPatternLength {
+			errs = append(errs, &ValidationError{
+				Line:    rule.Line,
+				Message: fmt.Sprintf("url pattern is too long: %d characters (up to %d characters)", len(rule.URL), MaxURLPatternLength),
+			})
+		} else if _, err := CompileHostPathMatcher(rule.URL); err != nil {
+			errs = append(errs, &ValidationError{Line: rule.Line, Message: err.Error()})
+		} else if line, ok := definedAt[rule.URL]; ok {
+			errs = append(errs, &ValidationError{
+				Line:    rule.Line,
+				Message: fmt.Sprintf("duplicated rule: %q is already defined at line %d", rule.URL, line),
+			})
+		} else {
+			definedAt[rule.URL] = rule.Line
+		}
+
+		if rule.ServiceName == "" {
+			errs = append(errs, &ValidationError{Line: rule.Line, Message: "service is required"})
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Line < errs[j].Line
+	})
+	return errs
+}
@@ -0,0 +1,60 @@
+package gaedispemu
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRawConfigValidate(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		config := &rawConfig{
+			Rules: []rawConfigRule{
+				{Line: 1, URL: "*/favicon.ico", ServiceName: "default"},
+				{Line: 2, URL: "*/mobile/*", ServiceName: "mobile-frontend"},
+			},
+		}
+		if err := config.validate(); err != nil {
+			t.Errorf("should be valid but got: %v", err)
+		}
+	})
+
+	t.Run("TooManyRules", func(t *testing.T) {
+		config := &rawConfig{}
+		for i := 0; i <= MaxConfigRules; i++ {
+			config.Rules = append(config.Rules, rawConfigRule{
+				Line:        i + 1,
+				URL:         fmt.Sprintf("*/path%d/*", i),
+				ServiceName: "default",
+			})
+		}
+
+		err := config.validate()
+		errs, ok := err.(ValidationErrors)
+		if !ok || len(errs) != 1 {
+			t.Fatalf("should be one validation error but got: %v", err)
+		}
+		if expected := "line 21: too many dispatch rules: 21 rules found (up to 20 rules)"; errs.Error() != expected {
+			t.Errorf("unexpected message: %s", errs.Error())
+		}
+	})
+
+	t.Run("NoURL", func(t *testing.T) {
+		config := &rawConfig{
+			Rules: []rawConfigRule{
+				{Line: 1, ServiceName: "default"},
+			},
+		}
+		if err := config.validate(); err == nil || err.Error() != "line 1: url is required" {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestValidationError(t *testing.T) {
+	if s := (&ValidationError{Message: "foo"}).Error(); s != "foo" {
+		t.Errorf("unexpected message: %s", s)
+	}
+	if s := (&ValidationError{Line: 1, Message: "foo"}).Error(); s != "line 1: foo" {
+		t.Errorf("unexpected message: %s", s)
+	}
+}
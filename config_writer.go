@@ -0,0 +1,20 @@
+package gaedispemu
+
+import (
+	"io"
+	"strings"
+)
+
+// ConfigWriter is an interface to write a config as dispatch.xml, dispatch.yaml or dispatch rules JSON
+type ConfigWriter interface {
+	WriteConfig(w io.Writer, config *Config) error
+}
+
+// splitPattern splits the URL pattern into the domain and the path (e.g. "*/mobile/*" -> "*", "/mobile/*")
+func splitPattern(pattern string) (domain, path string) {
+	index := strings.Index(pattern, "/")
+	if index == -1 {
+		return pattern, "/"
+	}
+	return pattern[:index], pattern[index:]
+}
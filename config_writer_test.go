@@ -0,0 +1,126 @@
+package gaedispemu
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type configSummary struct {
+	Comment string
+	Rules   []ruleSummary
+}
+
+type ruleSummary struct {
+	Pattern     string
+	ServiceName string
+	Comment     string
+}
+
+func summarizeConfig(config *Config) configSummary {
+	summary := configSummary{Comment: config.Comment}
+	for _, rule := range config.Rules {
+		summary.Rules = append(summary.Rules, ruleSummary{
+			Pattern:     rule.HostPathMatcher.(PatternMatcher).String(),
+			ServiceName: rule.ServiceName,
+			Comment:     rule.Comment,
+		})
+	}
+	return summary
+}
+
+// writeAndLoadConfig writes the config to a temporary file and loads it again
+func writeAndLoadConfig(t *testing.T, config *Config, fileName string, writer ConfigWriter, newLoader func(string) ConfigLoader) *Config {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "gaedispemu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	if err := writer.WriteConfig(&buf, config); err != nil {
+		t.Fatal(err)
+	}
+
+	filePath := filepath.Join(dir, fileName)
+	if err := ioutil.WriteFile(filePath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := newLoader(filePath).LoadConfig()
+	if err != nil {
+		t.Fatalf("failed to load the written config: %v\n%s", err, buf.String())
+	}
+	return loaded
+}
+
+func TestConfigWriterRoundTrip(t *testing.T) {
+	newYAMLLoader := func(filePath string) ConfigLoader { return NewYAMLConfigLoader(filePath) }
+	newXMLLoader := func(filePath string) ConfigLoader { return NewXMLConfigLoader(filePath) }
+	newJSONLoader := func(filePath string) ConfigLoader { return NewJSONConfigLoader(filePath) }
+
+	original, err := NewYAMLConfigLoader("./testdata/dispatch.yaml").LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := summarizeConfig(original)
+	if expected.Comment == "" || expected.Rules[0].Comment == "" {
+		t.Fatalf("the comments should be loaded: %#v", expected)
+	}
+
+	// yaml -> xml -> yaml keeps the rules and the comments
+	xmlConfig := writeAndLoadConfig(t, original, "dispatch.xml", NewXMLConfigWriter(), newXMLLoader)
+	if diff := cmp.Diff(expected, summarizeConfig(xmlConfig)); diff != "" {
+		t.Errorf("yaml -> xml: %s", diff)
+	}
+	yamlConfig := writeAndLoadConfig(t, xmlConfig, "dispatch.yaml", NewYAMLConfigWriter(), newYAMLLoader)
+	if diff := cmp.Diff(expected, summarizeConfig(yamlConfig)); diff != "" {
+		t.Errorf("yaml -> xml -> yaml: %s", diff)
+	}
+
+	// json keeps the rules only
+	jsonConfig := writeAndLoadConfig(t, original, "dispatch.json", NewJSONConfigWriter(), newJSONLoader)
+	expected.Comment = ""
+	for i := range expected.Rules {
+		expected.Rules[i].Comment = ""
+	}
+	if diff := cmp.Diff(expected, summarizeConfig(jsonConfig)); diff != "" {
+		t.Errorf("yaml -> json: %s", diff)
+	}
+	testConfig(t, jsonConfig)
+}
+
+type hostPathMatcherFunc func(host, path string) bool
+
+func (f hostPathMatcherFunc) MatchHostPath(host, path string) bool {
+	return f(host, path)
+}
+
+func TestConfigWriterUnknownPattern(t *testing.T) {
+	config := &Config{
+		Rules: []ConfigRule{
+			{ServiceName: "default", HostPathMatcher: hostPathMatcherFunc(func(host, path string) bool { return true })},
+		},
+	}
+
+	writers := map[string]ConfigWriter{
+		"yaml": NewYAMLConfigWriter(),
+		"xml":  NewXMLConfigWriter(),
+		"json": NewJSONConfigWriter(),
+	}
+	for name, writer := range writers {
+		var buf bytes.Buffer
+		err := writer.WriteConfig(&buf, config)
+		if err == nil {
+			t.Errorf("%s: should be failed for the matcher without the URL pattern", name)
+		} else if s := err.Error(); s != "Unknown URL pattern of the rule for service: default" {
+			t.Errorf("%s: unexpected error: %s", name, s)
+		}
+	}
+}
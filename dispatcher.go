@@ -1,33 +1,178 @@
 package gaedispemu
 
-import "fmt"
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DefaultServiceName is a name of the service which serves unmatched requests
+const DefaultServiceName = "default"
 
 // Dispatcher is a service dispatcher
 type Dispatcher interface {
 	Dispatch(host, path string) *Service
 }
 
+// Resolver is a dispatcher which can also tell how the service is chosen.
+// The dispatchers created by NewDispatcher and NewReloadableDispatcher implement it.
+type Resolver interface {
+	// Resolve dispatches as same as Dispatch and returns how the service is chosen
+	Resolve(host, path string) *DispatchResult
+}
+
+// Resolve dispatches by the dispatcher and returns how the service is chosen.
+// The reason is DispatchReasonUnknown if the dispatcher is not a Resolver.
+func Resolve(dispatcher Dispatcher, host, path string) *DispatchResult {
+	if resolver, ok := dispatcher.(Resolver); ok {
+		return resolver.Resolve(host, path)
+	}
+
+	service := dispatcher.Dispatch(host, path)
+	if service == nil {
+		return &DispatchResult{RuleIndex: -1, Reason: DispatchReasonNoMatch}
+	}
+	return &DispatchResult{Service: service, RuleIndex: -1, Reason: DispatchReasonUnknown}
+}
+
+// RequestDispatcher is a dispatcher which can dispatch the request itself.
+// The dispatchers created by NewDispatcher and NewReloadableDispatcher implement it.
+type RequestDispatcher interface {
+	// DispatchRequest resolves the service by the Host header and the path of the request
+	DispatchRequest(r *http.Request) *DispatchResult
+}
+
+// DispatchReason is a reason why the service is chosen
+type DispatchReason string
+
+const (
+	// DispatchReasonRule means the request matched a dispatch rule
+	DispatchReasonRule DispatchReason = "rule"
+	// DispatchReasonHostname means the hostname targeted the service or the version (e.g. SERVICE-dot-PROJECT.appspot.com)
+	DispatchReasonHostname DispatchReason = "hostname"
+	// DispatchReasonFallback means the request matched no dispatch rules and routed to the default service
+	DispatchReasonFallback DispatchReason = "fallback"
+	// DispatchReasonNoMatch means the request matched no dispatch rules and no service is chosen
+	DispatchReasonNoMatch DispatchReason = "no match"
+	// DispatchReasonUnknown means the dispatcher is not a Resolver and does not tell how the service is chosen
+	DispatchReasonUnknown DispatchReason = "unknown"
+)
+
+// DispatchResult is a result of dispatching
+type DispatchResult struct {
+	// Service is the chosen service (nil if no service is chosen)
+	Service *Service
+	// Rule is the matched rule (nil if no rules matched)
+	Rule *ConfigRule
+	// RuleIndex is the index of the matched rule in the config (-1 if no rules matched)
+	RuleIndex int
+	// Pattern is the URL pattern of the matched rule
+	Pattern string
+	Reason  DispatchReason
+	// Version is the version targeted by the hostname (e.g. VERSION-dot-SERVICE-dot-PROJECT.appspot.com)
+	Version string
+}
+
+// String returns the matched rule (e.g. "#2 */mobile/*") or the reason if no rules matched
+func (r *DispatchResult) String() string {
+	if r.Rule == nil {
+		return "(" + string(r.Reason) + ")"
+	}
+	return fmt.Sprintf("#%d %s", r.RuleIndex, r.Pattern)
+}
+
+// DispatcherOption is an option for the dispatcher
+type DispatcherOption func(*defaultDispatcher)
+
+// WithoutFallback makes the dispatcher return no service for unmatched requests
+// instead of routing them to the default service.
+func WithoutFallback() DispatcherOption {
+	return func(d *defaultDispatcher) {
+		d.fallback = false
+	}
+}
+
+// WithProject makes the dispatcher route the request by the hostname of the App Engine project
+// (e.g. SERVICE-dot-PROJECT.REGION.r.appspot.com) before dispatch rules.
+// regionID can be empty to accept only the hostnames without region ID.
+func WithProject(projectID, regionID string) DispatcherOption {
+	return func(d *defaultDispatcher) {
+		d.projectID = strings.ToLower(projectID)
+		d.regionID = strings.ToLower(regionID)
+	}
+}
+
 // NewDispatcher is a constructor of Dispatcher
-func NewDispatcher(services map[string]*Service, config *Config) (Dispatcher, error) {
+func NewDispatcher(services map[string]*Service, config *Config, opts ...DispatcherOption) (Dispatcher, error) {
 	for _, rule := range config.Rules {
 		if _, ok := services[rule.ServiceName]; !ok {
 			return nil, fmt.Errorf("Undefined backend for service: %s", rule.ServiceName)
 		}
 	}
-	return &defaultDispatcher{services: services, config: config}, nil
+
+	d := &defaultDispatcher{services: services, config: config, fallback: true}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d, nil
 }
 
+var (
+	_ Resolver          = (*defaultDispatcher)(nil)
+	_ RequestDispatcher = (*defaultDispatcher)(nil)
+)
+
 type defaultDispatcher struct {
-	services map[string]*Service
-	config   *Config
+	services  map[string]*Service
+	config    *Config
+	fallback  bool
+	projectID string
+	regionID  string
 }
 
 func (d *defaultDispatcher) Dispatch(host, path string) *Service {
-	for _, rule := range d.config.Rules {
+	return d.Resolve(host, path).Service
+}
+
+func (d *defaultDispatcher) Resolve(host, path string) *DispatchResult {
+	// App Engine routes the request to the default service if the hostname targets unknown service,
+	// so it falls through to the dispatch rules in the case.
+	if target := parseAppspotHost(host, d.projectID, d.regionID); target != nil {
+		if service, ok := d.services[target.Service]; ok {
+			if version, ok := service.Versions[target.Version]; ok {
+				return &DispatchResult{Service: version, Version: target.Version, RuleIndex: -1, Reason: DispatchReasonHostname}
+			}
+			return &DispatchResult{Service: service, RuleIndex: -1, Reason: DispatchReasonHostname}
+		}
+
+		// VERSION-dot-PROJECT.appspot.com targets the version of the default service
+		if service, ok := d.services[DefaultServiceName]; ok && target.Version == "" {
+			if version, ok := service.Versions[target.Service]; ok {
+				return &DispatchResult{Service: version, Version: target.Service, RuleIndex: -1, Reason: DispatchReasonHostname}
+			}
+		}
+	}
+
+	for i := range d.config.Rules {
+		rule := &d.config.Rules[i]
 		if rule.MatchHostPath(host, path) {
-			service := d.services[rule.ServiceName]
-			return service
+			result := &DispatchResult{Service: d.services[rule.ServiceName], Rule: rule, RuleIndex: i, Reason: DispatchReasonRule}
+			if m, ok := rule.HostPathMatcher.(PatternMatcher); ok {
+				result.Pattern = m.String()
+			}
+			return result
 		}
 	}
-	return nil
+
+	// App Engine routes the request to the default service if it matches no rules
+	if d.fallback {
+		if service, ok := d.services[DefaultServiceName]; ok {
+			return &DispatchResult{Service: service, RuleIndex: -1, Reason: DispatchReasonFallback}
+		}
+	}
+	return &DispatchResult{RuleIndex: -1, Reason: DispatchReasonNoMatch}
+}
+
+func (d *defaultDispatcher) DispatchRequest(r *http.Request) *DispatchResult {
+	return d.Resolve(getRequestHost(r), r.URL.Path)
 }
@@ -1,6 +1,8 @@
 package gaedispemu
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -89,7 +91,129 @@ func TestDispatcher(t *testing.T) {
 		{
 			Host:    "localhost",
 			Path:    "/",
-			Service: nil,
+			Service: services["default"],
+		},
+	}
+	for _, c := range cases {
+		service := dispatcher.Dispatch(c.Host, c.Path)
+		if diff := cmp.Diff(c.Service, service); diff != "" {
+			t.Errorf("`%s%s` is failed: diff=%s", c.Host, c.Path, diff)
+		}
+	}
+
+	t.Run("WithoutFallback", func(t *testing.T) {
+		dispatcher, err := NewDispatcher(services, config, WithoutFallback())
+		if err != nil {
+			t.Error(err)
+		}
+
+		if service := dispatcher.Dispatch("localhost", "/"); service != nil {
+			t.Errorf("should not dispatch unmatched request but got %s", service.Name)
+		}
+		if service := dispatcher.Dispatch("localhost", "/mobile/"); service != services["mobile-frontend"] {
+			t.Errorf("should dispatch matched request to mobile-frontend but got %v", service)
+		}
+	})
+
+	t.Run("NoDefaultService", func(t *testing.T) {
+		dispatcher, err := NewDispatcher(
+			map[string]*Service{
+				"foo": &Service{
+					Name:   "foo",
+					Origin: mustParseURL("http://localhost:8081"),
+				},
+			},
+			&Config{
+				Rules: []ConfigRule{
+					{
+						ServiceName:     "foo",
+						HostPathMatcher: mustCompileHostPathMatcher("*/foo/*"),
+					},
+				},
+			},
+		)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if service := dispatcher.Dispatch("localhost", "/"); service != nil {
+			t.Errorf("should not dispatch unmatched request but got %s", service.Name)
+		}
+	})
+}
+
+func TestDispatcherWithProject(t *testing.T) {
+	services := map[string]*Service{
+		"default": &Service{
+			Name:   "default",
+			Origin: mustParseURL("http://localhost:8081"),
+		},
+		"api": &Service{
+			Name:   "api",
+			Origin: mustParseURL("http://localhost:8082"),
+		},
+		"static": &Service{
+			Name:   "static",
+			Origin: mustParseURL("http://localhost:8083"),
+		},
+	}
+	config := &Config{
+		Rules: []ConfigRule{
+			{
+				ServiceName:     "static",
+				HostPathMatcher: mustCompileHostPathMatcher("*/static/*"),
+			},
+		},
+	}
+
+	dispatcher, err := NewDispatcher(services, config, WithProject("myproject", "uc"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	cases := []struct {
+		Host, Path string
+		Service    *Service
+	}{
+		{
+			Host:    "api-dot-myproject.uc.r.appspot.com",
+			Path:    "/",
+			Service: services["api"],
+		},
+		{
+			Host:    "api-dot-myproject.uc.r.appspot.com",
+			Path:    "/static/foo.png",
+			Service: services["api"],
+		},
+		{
+			Host:    "v1-dot-api-dot-myproject.uc.r.appspot.com",
+			Path:    "/",
+			Service: services["api"],
+		},
+		{
+			Host:    "api.myproject.appspot.com",
+			Path:    "/",
+			Service: services["api"],
+		},
+		{
+			Host:    "myproject.uc.r.appspot.com",
+			Path:    "/static/foo.png",
+			Service: services["static"],
+		},
+		{
+			Host:    "unknown-dot-myproject.uc.r.appspot.com",
+			Path:    "/static/foo.png",
+			Service: services["static"],
+		},
+		{
+			Host:    "unknown-dot-myproject.uc.r.appspot.com",
+			Path:    "/",
+			Service: services["default"],
+		},
+		{
+			Host:    "api-dot-otherproject.uc.r.appspot.com",
+			Path:    "/",
+			Service: services["default"],
 		},
 	}
 	for _, c := range cases {
@@ -99,3 +223,187 @@ func TestDispatcher(t *testing.T) {
 		}
 	}
 }
+
+func TestDispatcherWithVersions(t *testing.T) {
+	v1 := &Service{Name: "default", Version: "v1", Origin: mustParseURL("http://localhost:8084")}
+	v2 := &Service{Name: "api", Version: "v2", Origin: mustParseURL("http://localhost:8085")}
+	services := map[string]*Service{
+		"default": &Service{
+			Name:     "default",
+			Origin:   mustParseURL("http://localhost:8081"),
+			Versions: map[string]*Service{"v1": v1},
+		},
+		"api": &Service{
+			Name:     "api",
+			Origin:   mustParseURL("http://localhost:8082"),
+			Versions: map[string]*Service{"v2": v2},
+		},
+	}
+
+	dispatcher, err := NewDispatcher(services, &Config{}, WithProject("myproject", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Host    string
+		Service *Service
+		Version string
+	}{
+		{Host: "v2-dot-api-dot-myproject.appspot.com", Service: v2, Version: "v2"},
+		{Host: "i1-dot-v2-dot-api-dot-myproject.appspot.com", Service: v2, Version: "v2"},
+		{Host: "v1-dot-myproject.appspot.com", Service: v1, Version: "v1"},
+		{Host: "v3-dot-api-dot-myproject.appspot.com", Service: services["api"]},
+		{Host: "v1-dot-api-dot-myproject.appspot.com", Service: services["api"]},
+		{Host: "api-dot-myproject.appspot.com", Service: services["api"]},
+		{Host: "v2-dot-myproject.appspot.com", Service: services["default"]},
+	}
+	for _, c := range cases {
+		result := Resolve(dispatcher, c.Host, "/")
+		if result.Service != c.Service {
+			t.Errorf("`%s` is dispatched to %s (version: %q)", c.Host, result.Service.Name, result.Service.Version)
+		}
+		if result.Version != c.Version {
+			t.Errorf("`%s` has unexpected version: %q", c.Host, result.Version)
+		}
+	}
+}
+
+func TestDispatcherResolve(t *testing.T) {
+	loader := NewYAMLConfigLoader("./testdata/dispatch.yaml")
+	config, err := loader.LoadConfig()
+	if err != nil {
+		t.Error(err)
+	}
+
+	services := map[string]*Service{
+		"default": &Service{
+			Name:   "default",
+			Origin: mustParseURL("http://localhost:8081"),
+		},
+		"mobile-frontend": &Service{
+			Name:   "mobile-frontend",
+			Origin: mustParseURL("http://localhost:8082"),
+		},
+		"static-backend": &Service{
+			Name:   "static-backend",
+			Origin: mustParseURL("http://localhost:8083"),
+		},
+	}
+
+	dispatcher, err := NewDispatcher(services, config, WithProject("simple-sample", ""))
+	if err != nil {
+		t.Error(err)
+	}
+
+	cases := []struct {
+		Host, Path string
+		Expected   *DispatchResult
+	}{
+		{
+			Host: "localhost",
+			Path: "/mobile/favicon.ico",
+			Expected: &DispatchResult{
+				Service:   services["mobile-frontend"],
+				RuleIndex: 2,
+				Pattern:   "*/mobile/*",
+				Reason:    DispatchReasonRule,
+			},
+		},
+		{
+			Host: "static-backend-dot-simple-sample.appspot.com",
+			Path: "/favicon.ico",
+			Expected: &DispatchResult{
+				Service:   services["static-backend"],
+				RuleIndex: -1,
+				Reason:    DispatchReasonHostname,
+			},
+		},
+		{
+			Host: "localhost",
+			Path: "/",
+			Expected: &DispatchResult{
+				Service:   services["default"],
+				RuleIndex: -1,
+				Reason:    DispatchReasonFallback,
+			},
+		},
+	}
+	for _, c := range cases {
+		result := Resolve(dispatcher, c.Host, c.Path)
+		if c.Expected.RuleIndex == -1 && result.Rule != nil {
+			t.Errorf("`%s%s` is failed: should not match any rules", c.Host, c.Path)
+		} else if c.Expected.RuleIndex != -1 && result.Rule != &config.Rules[c.Expected.RuleIndex] {
+			t.Errorf("`%s%s` is failed: should match the rule #%d", c.Host, c.Path, c.Expected.RuleIndex)
+		}
+
+		// the rule is compared by the pointer above
+		result.Rule = nil
+		if diff := cmp.Diff(c.Expected, result); diff != "" {
+			t.Errorf("`%s%s` is failed: diff=%s", c.Host, c.Path, diff)
+		}
+	}
+
+	t.Run("DispatchRequest", func(t *testing.T) {
+		dispatcher := dispatcher.(RequestDispatcher)
+
+		r := httptest.NewRequest(http.MethodGet, "/mobile/favicon.ico", nil)
+		r.Host = "LocalHost:8080"
+
+		result := dispatcher.DispatchRequest(r)
+		if result.Rule != &config.Rules[2] {
+			t.Errorf("should match the rule #2 but got %s", result)
+		}
+		if s := result.String(); s != "#2 */mobile/*" {
+			t.Errorf("unexpected string: %s", s)
+		}
+
+		r.Host = "static-backend-dot-simple-sample.appspot.com"
+		result = dispatcher.DispatchRequest(r)
+		if result.Service != services["static-backend"] {
+			t.Errorf("should dispatch to static-backend but got %s", result)
+		}
+		if s := result.String(); s != "(hostname)" {
+			t.Errorf("unexpected string: %s", s)
+		}
+	})
+
+	t.Run("NoMatch", func(t *testing.T) {
+		dispatcher, err := NewDispatcher(services, config, WithoutFallback())
+		if err != nil {
+			t.Error(err)
+		}
+
+		result := Resolve(dispatcher, "localhost", "/")
+		expected := &DispatchResult{RuleIndex: -1, Reason: DispatchReasonNoMatch}
+		if diff := cmp.Diff(expected, result); diff != "" {
+			t.Errorf("unexpected result: diff=%s", diff)
+		}
+	})
+	t.Run("NotResolver", func(t *testing.T) {
+		dispatcher := dispatchFunc(func(host, path string) *Service {
+			if path == "/" {
+				return nil
+			}
+			return services["static-backend"]
+		})
+
+		result := Resolve(dispatcher, "localhost", "/favicon.ico")
+		expected := &DispatchResult{Service: services["static-backend"], RuleIndex: -1, Reason: DispatchReasonUnknown}
+		if diff := cmp.Diff(expected, result); diff != "" {
+			t.Errorf("unexpected result: diff=%s", diff)
+		}
+
+		result = Resolve(dispatcher, "localhost", "/")
+		expected = &DispatchResult{RuleIndex: -1, Reason: DispatchReasonNoMatch}
+		if diff := cmp.Diff(expected, result); diff != "" {
+			t.Errorf("unexpected result: diff=%s", diff)
+		}
+	})
+}
+
+type dispatchFunc func(host, path string) *Service
+
+func (f dispatchFunc) Dispatch(host, path string) *Service {
+	return f(host, path)
+}
@@ -0,0 +1,17 @@
+module github.com/karupanerura/gae-dispatcher-emulator
+
+go 1.24
+
+require (
+	github.com/BurntSushi/toml v1.5.0
+	github.com/MakeNowJust/heredoc v1.0.0
+	github.com/google/go-cmp v0.6.0
+	github.com/jessevdk/go-flags v1.5.0
+	github.com/motemen/go-loghttp v0.0.0-20231107055348-29ae44b293f4
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/motemen/go-nuts v0.0.0-20220604134737-2658d0104f31 // indirect
+	golang.org/x/sys v0.0.0-20211216021012-1d35b9e2eb4e // indirect
+)
@@ -0,0 +1,73 @@
+package gaedispemu
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gRPC status codes used by the proxy
+// SEE ALSO: https://github.com/grpc/grpc/blob/master/doc/statuscodes.md
+const (
+	grpcCodeUnknown          = 2
+	grpcCodeDeadlineExceeded = 4
+	grpcCodeUnimplemented    = 12
+	grpcCodeInternal         = 13
+	grpcCodeUnavailable      = 14
+)
+
+// isGRPCRequest returns true if the request is a gRPC call (e.g. Content-Type: application/grpc+proto)
+func isGRPCRequest(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	return contentType == "application/grpc" ||
+		strings.HasPrefix(contentType, "application/grpc+") ||
+		strings.HasPrefix(contentType, "application/grpc;")
+}
+
+// grpcCodeForHTTPStatus maps the status of the error responded by the proxy to gRPC status code.
+// SEE ALSO: https://github.com/grpc/grpc/blob/master/doc/http-grpc-status-mapping.md
+// The proxy responds 504 only when the request to the backend is timed out, so it is mapped to DEADLINE_EXCEEDED,
+// and 501 only when the service does not speak HTTP/2, so it is mapped to UNIMPLEMENTED.
+func grpcCodeForHTTPStatus(status int) int {
+	switch status {
+	case http.StatusBadRequest:
+		return grpcCodeInternal
+	case http.StatusNotFound, http.StatusNotImplemented:
+		return grpcCodeUnimplemented
+	case http.StatusGatewayTimeout:
+		return grpcCodeDeadlineExceeded
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return grpcCodeUnavailable
+	default:
+		return grpcCodeUnknown
+	}
+}
+
+// writeError responds the error as gRPC status for gRPC calls, or as plain text for the others
+func writeError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	if !isGRPCRequest(r) {
+		http.Error(w, message, status)
+		return
+	}
+
+	// Trailers-Only response which has the status in the headers
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(grpcCodeForHTTPStatus(status)))
+	w.Header().Set("Grpc-Message", encodeGRPCMessage(message))
+	w.WriteHeader(http.StatusOK)
+}
+
+// encodeGRPCMessage percent-encodes the message for grpc-message header
+func encodeGRPCMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < ' ' || '~' < c || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,196 @@
+package gaedispemu
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsGRPCRequest(t *testing.T) {
+	cases := map[string]bool{
+		"application/grpc":                true,
+		"application/grpc+proto":          true,
+		"application/grpc; charset=utf-8": true,
+		"application/grpc-web":            false,
+		"application/json":                false,
+		"":                                false,
+	}
+	for contentType, expected := range cases {
+		req := httptest.NewRequest("POST", "/helloworld.Greeter/SayHello", nil)
+		req.Header.Set("Content-Type", contentType)
+		if got := isGRPCRequest(req); got != expected {
+			t.Errorf("should be %v for %q", expected, contentType)
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	t.Run("HTTP", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		writeError(recorder, httptest.NewRequest("GET", "/", nil), "Failed to request for backend", http.StatusBadGateway)
+
+		res := recorder.Result()
+		if res.StatusCode != http.StatusBadGateway {
+			t.Errorf("Unexpected response status: %d", res.StatusCode)
+		}
+		if body := recorder.Body.String(); body != "Failed to request for backend\n" {
+			t.Errorf("Unexpected response body: %q", body)
+		}
+	})
+
+	t.Run("GRPC", func(t *testing.T) {
+		cases := map[int]string{
+			http.StatusBadRequest:          "13",
+			http.StatusNotFound:            "12",
+			http.StatusNotImplemented:      "12",
+			http.StatusBadGateway:          "14",
+			http.StatusServiceUnavailable:  "14",
+			http.StatusGatewayTimeout:      "4",
+			http.StatusInternalServerError: "2",
+		}
+		for status, code := range cases {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/helloworld.Greeter/SayHello", nil)
+			req.Header.Set("Content-Type", "application/grpc")
+			writeError(recorder, req, "100% failed", status)
+
+			res := recorder.Result()
+			if res.StatusCode != http.StatusOK {
+				t.Errorf("Unexpected response status: %d", res.StatusCode)
+			}
+			if v := res.Header.Get("Content-Type"); v != "application/grpc" {
+				t.Errorf("Unexpected Content-Type: %q", v)
+			}
+			if v := res.Header.Get("Grpc-Status"); v != code {
+				t.Errorf("grpc-status should be %s for %d but got %q", code, status, v)
+			}
+			if v := res.Header.Get("Grpc-Message"); v != "100%25 failed" {
+				t.Errorf("Unexpected grpc-message: %q", v)
+			}
+			if recorder.Body.Len() != 0 {
+				t.Errorf("Unexpected response body: %q", recorder.Body.String())
+			}
+		}
+	})
+}
+
+func TestEncodeGRPCMessage(t *testing.T) {
+	cases := map[string]string{
+		"No origin for the service: grpc": "No origin for the service: grpc",
+		"100%\n":                          "100%25%0A",
+		"café":                            "caf%C3%A9",
+	}
+	for message, expected := range cases {
+		if got := encodeGRPCMessage(message); got != expected {
+			t.Errorf("should be %q for %q but got %q", expected, message, got)
+		}
+	}
+}
+
+func TestProxyHandlerGRPC(t *testing.T) {
+	var received *http.Request
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		io.Copy(io.Discard, r.Body)
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.Write([]byte{0, 0, 0, 0, 0})
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", "")
+	}))
+	backend.Config.Protocols = newH2CProtocols()
+	backend.Start()
+	defer backend.Close()
+
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	services := map[string]*Service{
+		"default":     {Name: "default", Origin: mustParseURL(unreachable.URL)},
+		"greeter":     {Name: "greeter", Origin: mustParseURL(backend.URL), HTTP2: true},
+		"unreachable": {Name: "unreachable", Origin: mustParseURL(unreachable.URL), HTTP2: true},
+		"plain":       {Name: "plain", Origin: mustParseURL(backend.URL)},
+	}
+	config := &Config{
+		Rules: []ConfigRule{
+			{ServiceName: "greeter", HostPathMatcher: mustCompileHostPathMatcher("*/helloworld.Greeter/*")},
+			{ServiceName: "unreachable", HostPathMatcher: mustCompileHostPathMatcher("*/routeguide.RouteGuide/*")},
+			{ServiceName: "plain", HostPathMatcher: mustCompileHostPathMatcher("*/grpc.health.v1.Health/*")},
+		},
+	}
+	dispatcher, err := NewDispatcher(services, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := httptest.NewUnstartedServer(NewProxyHandler(dispatcher))
+	proxy.Config.Protocols = newH2CProtocols()
+	proxy.Start()
+	defer proxy.Close()
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+	call := func(path string) *http.Response {
+		t.Helper()
+
+		req, err := http.NewRequest("POST", proxy.URL+path, bytes.NewReader([]byte{0, 0, 0, 0, 0}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("Te", "trailers")
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(io.Discard, res.Body); err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	t.Run("Forward", func(t *testing.T) {
+		res := call("/helloworld.Greeter/SayHello")
+		if received == nil {
+			t.Fatal("should be forwarded to greeter")
+		}
+		if received.Proto != "HTTP/2.0" || received.URL.Path != "/helloworld.Greeter/SayHello" || received.Header.Get("Te") != "trailers" {
+			t.Errorf("Unexpected request: %s %s (te: %q)", received.Proto, received.URL.Path, received.Header.Get("Te"))
+		}
+		if v := res.Trailer.Get("Grpc-Status"); v != "0" {
+			t.Errorf("Unexpected grpc-status trailer: %q", v)
+		}
+	})
+
+	t.Run("Unreachable", func(t *testing.T) {
+		res := call("/routeguide.RouteGuide/GetFeature")
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("Unexpected response status: %d", res.StatusCode)
+		}
+		if v := res.Header.Get("Grpc-Status"); v != "14" {
+			t.Errorf("Unexpected grpc-status: %q", v)
+		}
+		if v := res.Header.Get("Grpc-Message"); v != "Failed to request for backend" {
+			t.Errorf("Unexpected grpc-message: %q", v)
+		}
+	})
+
+	t.Run("NoHTTP2", func(t *testing.T) {
+		received = nil
+		res := call("/grpc.health.v1.Health/Check")
+		if received != nil {
+			t.Error("should not be forwarded to the service without http2")
+		}
+		if v := res.Header.Get("Grpc-Status"); v != "12" {
+			t.Errorf("Unexpected grpc-status: %q", v)
+		}
+		if v := res.Header.Get("Grpc-Message"); v != "gRPC is not supported by the service without http2: plain" {
+			t.Errorf("Unexpected grpc-message: %q", v)
+		}
+	})
+}
@@ -0,0 +1,361 @@
+package gaedispemu
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HealthCheck is a setting of the health check for the backend of the service
+type HealthCheck struct {
+	// Path is the path to check (e.g. /_ah/health, /readiness)
+	Path string
+	// Interval is the interval between the checks (5 seconds if not positive)
+	Interval time.Duration
+	// Timeout is the timeout of each check (4 seconds if not positive)
+	Timeout time.Duration
+	// FailureThreshold is the number of consecutive failures to be unhealthy (2 if not positive)
+	FailureThreshold int
+	// SuccessThreshold is the number of consecutive successes to be healthy again (2 if not positive)
+	SuccessThreshold int
+}
+
+func (c *HealthCheck) withDefaults() *HealthCheck {
+	copied := *c
+	if copied.Interval <= 0 {
+		copied.Interval = 5 * time.Second
+	}
+	if copied.Timeout <= 0 {
+		copied.Timeout = 4 * time.Second
+	}
+	if copied.FailureThreshold <= 0 {
+		copied.FailureThreshold = 2
+	}
+	if copied.SuccessThreshold <= 0 {
+		copied.SuccessThreshold = 2
+	}
+	return &copied
+}
+
+// HealthState is the health of the instance of the service.
+// The instance is unhealthy until the first check passes, and it needs to pass the checks as many as SuccessThreshold
+// to be healthy again after it became unhealthy. The service is healthy while any of the instances are healthy.
+type HealthState struct {
+	Service string `json:"service"`
+	// Origin is the origin of the instance (empty if the service has no origin)
+	Origin  string    `json:"origin,omitempty"`
+	Healthy bool      `json:"healthy"`
+	Checked time.Time `json:"checked"`
+	// Error is the reason of the last failure
+	Error string `json:"error,omitempty"`
+
+	successes int
+	failures  int
+	// passed is true if the instance has passed any checks
+	passed bool
+}
+
+// HealthChangeHandler is called when the health of the instance is changed
+type HealthChangeHandler func(state HealthState)
+
+// HealthCheckerOption is an option for the health checker
+type HealthCheckerOption func(*HealthChecker)
+
+// WithHealthChangeHandler sets the handler called when the health of the instance is changed
+func WithHealthChangeHandler(handler HealthChangeHandler) HealthCheckerOption {
+	return func(c *HealthChecker) {
+		c.handler = handler
+	}
+}
+
+// WithHealthCheckClient sets the HTTP client for the checks (the client with the transport to the backend of each service is default)
+func WithHealthCheckClient(client *http.Client) HealthCheckerOption {
+	return func(c *HealthChecker) {
+		c.client = client
+	}
+}
+
+// HealthChecker checks the health of each instance of the services which have HealthCheck.
+// It is also an http.Handler to show the states in JSON for the admin endpoint.
+type HealthChecker struct {
+	services []*Service
+	client   *http.Client
+	handler  HealthChangeHandler
+
+	mu sync.RWMutex
+	// states are the states of the instances by the service name
+	states  map[string][]*HealthState
+	changed chan struct{}
+}
+
+var _ http.Handler = (*HealthChecker)(nil)
+
+// NewHealthChecker creates a health checker for the services which have HealthCheck
+func NewHealthChecker(services map[string]*Service, opts ...HealthCheckerOption) *HealthChecker {
+	c := &HealthChecker{
+		handler: func(HealthState) {},
+		states:  map[string][]*HealthState{},
+		changed: make(chan struct{}),
+	}
+	for _, service := range services {
+		if service.HealthCheck == nil {
+			continue
+		}
+
+		c.services = append(c.services, service)
+		origins := service.Instances()
+		if len(origins) == 0 {
+			c.states[service.Name] = []*HealthState{{Service: service.Name}}
+			continue
+		}
+		for _, origin := range origins {
+			c.states[service.Name] = append(c.states[service.Name], &HealthState{Service: service.Name, Origin: origin.String()})
+		}
+	}
+	sort.Slice(c.services, func(i, j int) bool {
+		return c.services[i].Name < c.services[j].Name
+	})
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Len returns the number of the checked services
+func (c *HealthChecker) Len() int {
+	return len(c.services)
+}
+
+// Run checks the services periodically until the context is done
+func (c *HealthChecker) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, service := range c.services {
+		wg.Add(1)
+		go func(service *Service) {
+			defer wg.Done()
+			c.runService(ctx, service)
+		}(service)
+	}
+	wg.Wait()
+}
+
+func (c *HealthChecker) runService(ctx context.Context, service *Service) {
+	// the service is kept unhealthy if the transport cannot be configured (e.g. the CA file is broken)
+	client, clientErr := c.client, error(nil)
+	if client == nil {
+		var transport http.RoundTripper
+		transport, clientErr = newServiceTransport(service)
+		client = &http.Client{Transport: transport}
+	}
+
+	check := service.HealthCheck.withDefaults()
+	origins := service.Instances()
+	var wg sync.WaitGroup
+	for i := range c.states[service.Name] {
+		var origin *url.URL
+		if len(origins) != 0 {
+			origin = origins[i]
+		}
+
+		wg.Add(1)
+		go func(i int, origin *url.URL) {
+			defer wg.Done()
+
+			ticker := time.NewTicker(check.Interval)
+			defer ticker.Stop()
+			for {
+				err := clientErr
+				if err == nil {
+					err = c.check(ctx, client, origin, check)
+				}
+				c.record(service.Name, i, check, err)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(i, origin)
+	}
+	wg.Wait()
+}
+
+func (c *HealthChecker) check(ctx context.Context, client *http.Client, origin *url.URL, check *HealthCheck) error {
+	if origin == nil {
+		return fmt.Errorf("no origin")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, check.Timeout)
+	defer cancel()
+
+	ref, err := url.Parse(check.Path)
+	if err != nil {
+		return err
+	}
+
+	u := origin.ResolveReference(ref)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || 300 <= res.StatusCode {
+		return fmt.Errorf("%s responded %s", check.Path, res.Status)
+	}
+	return nil
+}
+
+func (c *HealthChecker) record(name string, index int, check *HealthCheck, err error) {
+	c.mu.Lock()
+	state := c.states[name][index]
+	state.Checked = time.Now()
+	wasHealthy := state.Healthy
+	if err == nil {
+		state.successes++
+		state.failures = 0
+		state.Error = ""
+		// the first success makes the instance healthy not to reject the requests long after launching
+		if !state.passed || state.successes >= check.SuccessThreshold {
+			state.Healthy = true
+		}
+		state.passed = true
+	} else {
+		state.failures++
+		state.successes = 0
+		state.Error = err.Error()
+		if state.failures >= check.FailureThreshold {
+			state.Healthy = false
+		}
+	}
+
+	snapshot := *state
+	if wasHealthy != state.Healthy {
+		close(c.changed)
+		c.changed = make(chan struct{})
+	}
+	c.mu.Unlock()
+
+	if wasHealthy != snapshot.Healthy {
+		c.handler(snapshot)
+	}
+}
+
+// ServiceHealth returns an error if none of the instances of the service are healthy (nil if the service has no HealthCheck)
+func (c *HealthChecker) ServiceHealth(name string) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	states, ok := c.states[name]
+	if !ok || c.serviceHealthy(name) {
+		return nil
+	}
+
+	var reasons []string
+	for _, state := range states {
+		if state.Error == "" {
+			continue
+		}
+		if len(states) == 1 {
+			reasons = append(reasons, state.Error)
+		} else {
+			reasons = append(reasons, state.Origin+": "+state.Error)
+		}
+	}
+	if len(reasons) == 0 {
+		return fmt.Errorf("The service %s is not healthy yet", name)
+	}
+	return fmt.Errorf("The service %s is unhealthy: %s", name, strings.Join(reasons, ", "))
+}
+
+// serviceHealthy returns true if any of the instances of the service are healthy (c.mu must be locked)
+func (c *HealthChecker) serviceHealthy(name string) bool {
+	for _, state := range c.states[name] {
+		if state.Healthy {
+			return true
+		}
+	}
+	return false
+}
+
+// instanceHealthy returns false if the instance of the service is unhealthy (true if the instance is not checked)
+func (c *HealthChecker) instanceHealthy(name string, origin *url.URL) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, state := range c.states[name] {
+		if state.Origin == origin.String() {
+			return state.Healthy
+		}
+	}
+	return true
+}
+
+// States returns the health of the instances in the order of the service name
+func (c *HealthChecker) States() []HealthState {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var states []HealthState
+	for _, service := range c.services {
+		for _, state := range c.states[service.Name] {
+			states = append(states, *state)
+		}
+	}
+	return states
+}
+
+// WaitHealthy waits until all services are healthy
+func (c *HealthChecker) WaitHealthy(ctx context.Context) error {
+	for {
+		c.mu.RLock()
+		changed := c.changed
+		var unhealthy []string
+		for _, service := range c.services {
+			if !c.serviceHealthy(service.Name) {
+				unhealthy = append(unhealthy, service.Name)
+			}
+		}
+		c.mu.RUnlock()
+		if len(unhealthy) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("The services are not healthy: %v (%v)", unhealthy, ctx.Err())
+		case <-changed:
+		}
+	}
+}
+
+// ServeHTTP responds the health of the instances in JSON with 200 if all services are healthy or 503
+func (c *HealthChecker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	states := c.States()
+	status := http.StatusOK
+	c.mu.RLock()
+	for _, service := range c.services {
+		if !c.serviceHealthy(service.Name) {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	c.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Services []HealthState `json:"services"`
+	}{Services: states})
+}
@@ -0,0 +1,218 @@
+package gaedispemu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHealthChecker(t *testing.T) {
+	var healthy int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_ah/health" || atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer backend.Close()
+
+	check := &HealthCheck{Path: "/_ah/health", Interval: 10 * time.Millisecond, FailureThreshold: 1, SuccessThreshold: 1}
+	services := map[string]*Service{
+		"default": {Name: "default", Origin: mustParseURL(backend.URL), HealthCheck: check},
+		"static":  {Name: "static", Origin: mustParseURL(backend.URL)},
+	}
+
+	changes := make(chan HealthState, 10)
+	checker := NewHealthChecker(services, WithHealthChangeHandler(func(state HealthState) {
+		changes <- state
+	}))
+	if checker.Len() != 1 {
+		t.Errorf("should check only the service with HealthCheck, but got %d", checker.Len())
+	}
+
+	// unhealthy until the first success
+	if err := checker.ServiceHealth("default"); err == nil || err.Error() != "The service default is not healthy yet" {
+		t.Errorf("unexpected health: %v", err)
+	}
+	if err := checker.ServiceHealth("static"); err != nil {
+		t.Errorf("the service without HealthCheck should be healthy: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go checker.Run(ctx)
+
+	time.Sleep(50 * time.Millisecond)
+	if err := checker.ServiceHealth("default"); err == nil || err.Error() != "The service default is unhealthy: /_ah/health responded 503 Service Unavailable" {
+		t.Errorf("unexpected health: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	checker.ServeHTTP(recorder, httptest.NewRequest("GET", "/health", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status: %d", recorder.Code)
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	waitCtx, waitCancel := context.WithTimeout(ctx, time.Second)
+	defer waitCancel()
+	if err := checker.WaitHealthy(waitCtx); err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.ServiceHealth("default"); err != nil {
+		t.Errorf("should be healthy: %v", err)
+	}
+	if state := <-changes; state.Service != "default" || !state.Healthy {
+		t.Errorf("unexpected change: %+v", state)
+	}
+
+	recorder = httptest.NewRecorder()
+	checker.ServeHTTP(recorder, httptest.NewRequest("GET", "/health", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("unexpected status: %d", recorder.Code)
+	}
+
+	var body struct {
+		Services []struct {
+			Service string `json:"service"`
+			Healthy bool   `json:"healthy"`
+		} `json:"services"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]struct {
+		Service string `json:"service"`
+		Healthy bool   `json:"healthy"`
+	}{{Service: "default", Healthy: true}}, body.Services); diff != "" {
+		t.Errorf("unexpected body: %s", diff)
+	}
+}
+
+func TestHealthCheckerThresholds(t *testing.T) {
+	services := map[string]*Service{
+		"default": {Name: "default", Origin: mustParseURL("http://localhost:8081"), HealthCheck: &HealthCheck{Path: "/"}},
+	}
+	checker := NewHealthChecker(services)
+	check := services["default"].HealthCheck.withDefaults()
+
+	errFailed := errors.New("failed")
+	steps := []struct {
+		Err     error
+		Healthy bool
+	}{
+		{Err: errFailed, Healthy: false},
+		// healthy by the first success
+		{Err: nil, Healthy: true},
+		{Err: errFailed, Healthy: true},
+		{Err: errFailed, Healthy: false},
+		// healthy again by the successes as many as SuccessThreshold
+		{Err: nil, Healthy: false},
+		{Err: nil, Healthy: true},
+	}
+	for i, step := range steps {
+		checker.record("default", 0, check, step.Err)
+		if healthy := checker.ServiceHealth("default") == nil; healthy != step.Healthy {
+			t.Errorf("step %d: expected healthy=%v but got %v", i, step.Healthy, healthy)
+		}
+	}
+}
+
+func TestHealthCheckWithDefaults(t *testing.T) {
+	check := &HealthCheck{Path: "/", Interval: -time.Second, Timeout: 0, FailureThreshold: -1, SuccessThreshold: 3}
+	expected := &HealthCheck{Path: "/", Interval: 5 * time.Second, Timeout: 4 * time.Second, FailureThreshold: 2, SuccessThreshold: 3}
+	if diff := cmp.Diff(expected, check.withDefaults()); diff != "" {
+		t.Errorf("unexpected health check: %s", diff)
+	}
+}
+
+func TestHealthCheckerWaitHealthyTimeout(t *testing.T) {
+	services := map[string]*Service{
+		"default": {Name: "default", HealthCheck: &HealthCheck{Path: "/", Interval: 10 * time.Millisecond}},
+	}
+	checker := NewHealthChecker(services)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	go checker.Run(ctx)
+
+	if err := checker.WaitHealthy(ctx); err == nil {
+		t.Error("should be error")
+	}
+	if err := checker.ServiceHealth("default"); err == nil || err.Error() != "The service default is unhealthy: no origin" {
+		t.Errorf("unexpected health: %v", err)
+	}
+}
+
+func TestProxyHandlerUnhealthy(t *testing.T) {
+	services := map[string]*Service{
+		"default": {Name: "default", Origin: mustParseURL("http://203.0.113.1"), HealthCheck: &HealthCheck{Path: "/"}},
+	}
+	dispatcher, err := NewDispatcher(services, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	NewProxyHandler(dispatcher, WithHealthChecker(NewHealthChecker(services))).ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status: %d", recorder.Code)
+	}
+	if body := recorder.Body.String(); body != "The service default is not healthy yet\n" {
+		t.Errorf("unexpected body: %q", body)
+	}
+}
+
+func TestProxyHandlerUnhealthyInstance(t *testing.T) {
+	alive := httptest.NewServer(getBackendHandler("alive"))
+	defer alive.Close()
+	dead := httptest.NewServer(getBackendHandler("dead"))
+	dead.Close()
+
+	service := &Service{Name: "default", HealthCheck: &HealthCheck{Path: "/", Interval: 10 * time.Millisecond, FailureThreshold: 1, SuccessThreshold: 1}}
+	service.SetOrigins([]*url.URL{mustParseURL(dead.URL), mustParseURL(alive.URL)})
+	services := map[string]*Service{"default": service}
+	dispatcher, err := NewDispatcher(services, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checker := NewHealthChecker(services)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go checker.Run(ctx)
+	if err := checker.WaitHealthy(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the dead instance is never chosen
+	handler := NewProxyHandler(dispatcher, WithHealthChecker(checker))
+	for i := 0; i < 4; i++ {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+		if recorder.Code != http.StatusOK {
+			t.Errorf("unexpected status: %d", recorder.Code)
+		}
+		if s := recorder.Result().Header.Get("Service"); s != "alive" {
+			t.Errorf("should proxy to alive, but got %s", s)
+		}
+	}
+
+	states := checker.States()
+	if len(states) != 2 {
+		t.Fatalf("should have the states of the instances: %v", states)
+	}
+	if states[0].Origin != dead.URL || states[0].Healthy {
+		t.Errorf("the dead instance should be unhealthy: %+v", states[0])
+	}
+	if states[1].Origin != alive.URL || !states[1].Healthy {
+		t.Errorf("the alive instance should be healthy: %+v", states[1])
+	}
+}
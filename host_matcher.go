@@ -13,23 +13,23 @@ var errInvalidHostPattern = errors.New("Invalid Host Pattern")
 
 var hostPatternRegexp = regexp.MustCompile(`^\*?[^\*]+$`)
 
-func compileHostMatcher(pattern string) (hostMatcher, error) {
+func compileHostMatcher(pattern string) (hostMatcher, PatternPart, error) {
 	if pattern == "*" {
-		return passThroughHostMatcher, nil
+		return passThroughHostMatcher, PatternPart{Pattern: pattern, Wildcard: WildcardAny}, nil
 	}
 
 	if !hostPatternRegexp.MatchString(pattern) {
-		return nil, errInvalidHostPattern
+		return nil, PatternPart{}, errInvalidHostPattern
 	}
 
 	if pattern[0] == '*' {
 		suffix := pattern[1:len(pattern)]
 		matcher := suffixStringMathcer{suffix: suffix}
-		return &stringHostMatcher{stringMatcher: matcher}, nil
+		return &stringHostMatcher{stringMatcher: matcher}, PatternPart{Pattern: pattern, Wildcard: WildcardSuffix, Value: suffix}, nil
 	}
 
 	matcher := justStringMathcer{expected: pattern}
-	return &stringHostMatcher{stringMatcher: matcher}, nil
+	return &stringHostMatcher{stringMatcher: matcher}, PatternPart{Pattern: pattern, Wildcard: WildcardNone, Value: pattern}, nil
 }
 
 type passThroughHostMatcherType struct{}
@@ -10,15 +10,47 @@ type HostPathMatcher interface {
 	MatchHostPath(host, path string) bool
 }
 
+// PatternMatcher is a matcher which knows the original URL pattern.
+// The matchers compiled by CompileHostPathMatcher implement it.
+type PatternMatcher interface {
+	HostPathMatcher
+
+	// String returns the original URL pattern
+	String() string
+
+	// Host returns the host part of the URL pattern
+	Host() PatternPart
+
+	// Path returns the path part of the URL pattern
+	Path() PatternPart
+}
+
+var _ PatternMatcher = (*genericHostPathMatcher)(nil)
+
 type genericHostPathMatcher struct {
 	hostMatcher
 	pathMatcher
+	pattern string
+	host    PatternPart
+	path    PatternPart
 }
 
 func (m *genericHostPathMatcher) MatchHostPath(host, path string) bool {
 	return m.MatchHost(host) && m.MatchPath(path)
 }
 
+func (m *genericHostPathMatcher) String() string {
+	return m.pattern
+}
+
+func (m *genericHostPathMatcher) Host() PatternPart {
+	return m.host
+}
+
+func (m *genericHostPathMatcher) Path() PatternPart {
+	return m.path
+}
+
 // CompileHostPathMatcher is constructor for HostPathMatcher
 func CompileHostPathMatcher(pattern string) (HostPathMatcher, error) {
 	index := strings.Index(pattern, "/")
@@ -27,12 +59,12 @@ func CompileHostPathMatcher(pattern string) (HostPathMatcher, error) {
 	}
 
 	host, path := pattern[:index], pattern[index+1:]
-	hostMatcher, err := compileHostMatcher(host)
+	hostMatcher, hostPart, err := compileHostMatcher(host)
 	if err != nil {
 		return nil, fmt.Errorf("Invalid URL pattern: %s (%s)", pattern, err.Error())
 	}
 
-	pathMatcher, err := compilePathMatcher(path)
+	pathMatcher, pathPart, err := compilePathMatcher(path)
 	if err != nil {
 		return nil, fmt.Errorf("Invalid URL pattern: %s (%s)", pattern, err.Error())
 	}
@@ -40,5 +72,8 @@ func CompileHostPathMatcher(pattern string) (HostPathMatcher, error) {
 	return &genericHostPathMatcher{
 		hostMatcher: hostMatcher,
 		pathMatcher: pathMatcher,
+		pattern:     pattern,
+		host:        hostPart,
+		path:        pathPart,
 	}, nil
 }
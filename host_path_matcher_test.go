@@ -1,6 +1,10 @@
 package gaedispemu
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
 
 func TestCompileHostPathMatcher(t *testing.T) {
 	t.Run("ExpectedHost", func(t *testing.T) {
@@ -80,6 +84,64 @@ func TestCompileHostPathMatcher(t *testing.T) {
 		}
 	})
 
+	t.Run("String", func(t *testing.T) {
+		m, err := CompileHostPathMatcher("*.example.com/service1/*")
+		if err != nil {
+			t.Error(err)
+		}
+
+		if s := m.(PatternMatcher).String(); s != "*.example.com/service1/*" {
+			t.Errorf("should be the original pattern but got %s", s)
+		}
+	})
+
+	t.Run("Parts", func(t *testing.T) {
+		cases := []struct {
+			Pattern    string
+			Host, Path PatternPart
+		}{
+			{
+				Pattern: "*/favicon.ico",
+				Host:    PatternPart{Pattern: "*", Wildcard: WildcardAny},
+				Path:    PatternPart{Pattern: "/favicon.ico", Wildcard: WildcardNone, Value: "/favicon.ico"},
+			},
+			{
+				Pattern: "simple-sample.appspot.com/",
+				Host:    PatternPart{Pattern: "simple-sample.appspot.com", Wildcard: WildcardNone, Value: "simple-sample.appspot.com"},
+				Path:    PatternPart{Pattern: "/", Wildcard: WildcardAny, Value: "/"},
+			},
+			{
+				Pattern: "*.example.com/mobile/*",
+				Host:    PatternPart{Pattern: "*.example.com", Wildcard: WildcardSuffix, Value: ".example.com"},
+				Path:    PatternPart{Pattern: "/mobile/*", Wildcard: WildcardPrefix, Value: "/mobile/"},
+			},
+			{
+				Pattern: "example.com/*",
+				Host:    PatternPart{Pattern: "example.com", Wildcard: WildcardNone, Value: "example.com"},
+				Path:    PatternPart{Pattern: "/*", Wildcard: WildcardAny, Value: "/"},
+			},
+		}
+		for _, c := range cases {
+			m, err := CompileHostPathMatcher(c.Pattern)
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+
+			pm, ok := m.(PatternMatcher)
+			if !ok {
+				t.Errorf("%s should be compiled to PatternMatcher", c.Pattern)
+				continue
+			}
+			if diff := cmp.Diff(c.Host, pm.Host()); diff != "" {
+				t.Errorf("unexpected host part of %s: %s", c.Pattern, diff)
+			}
+			if diff := cmp.Diff(c.Path, pm.Path()); diff != "" {
+				t.Errorf("unexpected path part of %s: %s", c.Pattern, diff)
+			}
+		}
+	})
+
 	t.Run("Invalid", func(t *testing.T) {
 		patterns := []string{
 			"hostnameonly",
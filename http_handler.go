@@ -1,9 +1,19 @@
 package gaedispemu
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"log"
+	"net"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // ErrorReporter is error reporter interface for proxy handler
@@ -25,77 +35,313 @@ func (r ErrorReporterFunc) ReportError(err error) {
 	r(err)
 }
 
+// ProxyHandlerOption is an option for the proxy handler
+type ProxyHandlerOption func(*proxyHandler)
+
+// WithErrorReporter sets the error reporter for the proxy handler
+func WithErrorReporter(errorReporter ErrorReporter) ProxyHandlerOption {
+	return func(h *proxyHandler) {
+		h.errorReporter = errorReporter
+	}
+}
+
+// WithHostHeader makes the proxy handler read the host to dispatch from the given header
+// (e.g. X-Forwarded-Host) instead of the Host header when the request has it.
+func WithHostHeader(name string) ProxyHandlerOption {
+	return func(h *proxyHandler) {
+		h.hostHeader = http.CanonicalHeaderKey(name)
+	}
+}
+
+// WithHealthChecker makes the proxy handler respond 503 for the service while it is unhealthy
+func WithHealthChecker(checker *HealthChecker) ProxyHandlerOption {
+	return func(h *proxyHandler) {
+		h.healthChecker = checker
+	}
+}
+
+// WithTransportWrapper wraps the transports to the backends of all services (e.g. to log the requests)
+func WithTransportWrapper(wrapper TransportWrapper) ProxyHandlerOption {
+	return func(h *proxyHandler) {
+		h.transportWrapper = wrapper
+	}
+}
+
 // NewProxyHandler creates a new proxy handler
-func NewProxyHandler(dispatcher Dispatcher) http.Handler {
-	return &proxyHandler{dispatcher: dispatcher, errorReporter: nopErrorReporter}
+func NewProxyHandler(dispatcher Dispatcher, opts ...ProxyHandlerOption) http.Handler {
+	h := &proxyHandler{dispatcher: dispatcher, errorReporter: nopErrorReporter}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
 // NewProxyHandlerWithReporter creates a new proxy handler with error reporter
-func NewProxyHandlerWithReporter(dispatcher Dispatcher, errorReporter ErrorReporter) http.Handler {
-	return &proxyHandler{dispatcher: dispatcher, errorReporter: errorReporter}
+func NewProxyHandlerWithReporter(dispatcher Dispatcher, errorReporter ErrorReporter, opts ...ProxyHandlerOption) http.Handler {
+	return NewProxyHandler(dispatcher, append([]ProxyHandlerOption{WithErrorReporter(errorReporter)}, opts...)...)
 }
 
 type proxyHandler struct {
 	dispatcher    Dispatcher
 	errorReporter ErrorReporter
+	hostHeader    string
+	healthChecker *HealthChecker
+
+	upgradeHandler UpgradeHandler
+	upgradeContext context.Context
+
+	balancersMu sync.Mutex
+	balancers   map[*Service]*loadBalancer
+
+	transportWrapper TransportWrapper
+	transportsMu     sync.Mutex
+	transports       map[*Service]http.RoundTripper
 }
 
 var _ http.Handler = (*proxyHandler)(nil)
 
+// DispatchRuleHeader is a response header to tell which rule is matched for debugging
+const DispatchRuleHeader = "X-Dispatch-Rule"
+
+// DispatchVersionHeader is a response header to tell which version of the service is chosen for debugging
+const DispatchVersionHeader = "X-Dispatch-Version"
+
 func (h *proxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	service := h.dispatcher.Dispatch(r.URL.Host, r.URL.Path)
+	result := Resolve(h.dispatcher, h.getHost(r), r.URL.Path)
+	w.Header().Set(DispatchRuleHeader, result.String())
+
+	service := result.Service
 	if service == nil {
-		http.Error(w, "No such backend for the URL: "+r.URL.Path, http.StatusNotFound)
+		writeError(w, r, "No such backend for the URL: "+r.URL.Path, http.StatusNotFound)
+		return
+	}
+	if result.Version == "" {
+		if version := service.chooseVersion(w, r); version != nil {
+			service = version
+		}
+	}
+	if service.Version != "" {
+		w.Header().Set(DispatchVersionHeader, service.Version)
+	}
+
+	if service.Origin == nil {
+		writeError(w, r, "No origin for the service: "+service.Name, http.StatusBadGateway)
+		return
+	}
+	// gRPC needs HTTP/2 to the backend for the trailers
+	if isGRPCRequest(r) && !service.HTTP2 {
+		writeError(w, r, "gRPC is not supported by the service without http2: "+service.Name, http.StatusNotImplemented)
 		return
 	}
+	// the versions are not health-checked even if the service is
+	if h.healthChecker != nil && service.Version == "" {
+		if err := h.healthChecker.ServiceHealth(service.Name); err != nil {
+			writeError(w, r, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	transport, err := h.getTransport(service)
+	if err != nil {
+		writeError(w, r, "Failed to configure the transport for the service: "+service.Name, http.StatusBadGateway)
+		h.errorReporter.ReportError(err)
+		return
+	}
+
+	var healthy func(origin *url.URL) bool
+	if h.healthChecker != nil {
+		healthy = func(origin *url.URL) bool {
+			return h.healthChecker.instanceHealthy(service.Name, origin)
+		}
+	}
+	origin, release := h.getLoadBalancer(service).acquire(w, r, healthy)
+	defer release()
 
-	next := &serviceProxyHandler{service: service, errorReporter: h.errorReporter}
+	if h.upgradeContext != nil && isUpgradeRequest(r) {
+		var cancel context.CancelFunc
+		r, cancel = withUpgradeContext(r, h.upgradeContext)
+		defer cancel()
+	}
+
+	next := &serviceProxyHandler{
+		service:        service,
+		origin:         origin,
+		transport:      transport,
+		errorReporter:  h.errorReporter,
+		upgradeHandler: h.upgradeHandler,
+	}
 	next.ServeHTTP(w, r)
 }
 
-// SEE ALSO: RFC2616
-var nopHeadersByHop = []string{
-	"Connection",
-	"Keep-Alive",
-	"Proxy-Authenticate",
-	"Proxy-Authorization",
-	"TE",
-	"Trailer",
-	"Transfer-Encoding",
-	"Upgrade",
+func (h *proxyHandler) getLoadBalancer(service *Service) *loadBalancer {
+	h.balancersMu.Lock()
+	defer h.balancersMu.Unlock()
+
+	if h.balancers == nil {
+		h.balancers = map[*Service]*loadBalancer{}
+	}
+	if b, ok := h.balancers[service]; ok {
+		return b
+	}
+
+	b := newLoadBalancer(service)
+	h.balancers[service] = b
+	return b
+}
+
+// getTransport returns the transport for the service to reuse the connections to the backends
+func (h *proxyHandler) getTransport(service *Service) (http.RoundTripper, error) {
+	h.transportsMu.Lock()
+	defer h.transportsMu.Unlock()
+
+	if h.transports == nil {
+		h.transports = map[*Service]http.RoundTripper{}
+	}
+	if t, ok := h.transports[service]; ok {
+		return t, nil
+	}
+
+	t, err := newServiceTransport(service)
+	if err != nil {
+		return nil, err
+	}
+	if h.transportWrapper != nil {
+		t = h.transportWrapper(t)
+	}
+	h.transports[service] = t
+	return t, nil
+}
+
+func (h *proxyHandler) getHost(r *http.Request) string {
+	if h.hostHeader != "" {
+		// the left-most value is the original one when the request passed through several proxies
+		if values := r.Header.Get(h.hostHeader); values != "" {
+			host := strings.TrimSpace(strings.Split(values, ",")[0])
+			return normalizeHost(host)
+		}
+	}
+
+	return getRequestHost(r)
+}
+
+// getRequestHost returns the normalized host from the Host header of the request
+func getRequestHost(r *http.Request) string {
+	// r.URL.Host is available only for the absolute-form request (forward proxy request)
+	if r.Host != "" {
+		return normalizeHost(r.Host)
+	}
+	return normalizeHost(r.URL.Host)
+}
+
+// normalizeHost strips port and lower-cases the host to match with dispatch rules
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(host, ".")
+	return strings.ToLower(host)
 }
 
 type serviceProxyHandler struct {
-	service       *Service
-	errorReporter ErrorReporter
+	service *Service
+	// origin is the instance of the service to request (service.Origin if nil)
+	origin *url.URL
+	// transport is the transport to the backend (http.DefaultTransport if nil)
+	transport      http.RoundTripper
+	errorReporter  ErrorReporter
+	upgradeHandler UpgradeHandler
 }
 
 var _ http.Handler = (*serviceProxyHandler)(nil)
 
+// discardLogger silences httputil.ReverseProxy because the errors are given to the error reporter
+var discardLogger = log.New(io.Discard, "", 0)
+
 func (h *serviceProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the timeout is applied until the response headers not to cut the streamed response body,
+	// and is not applied to the upgraded connection which lives long (e.g. WebSocket)
+	var stopTimeout func() bool
+	if h.service.Timeout != 0 && !isUpgradeRequest(r) {
+		ctx, cancel := context.WithCancelCause(r.Context())
+		defer cancel(nil)
+		timer := time.AfterFunc(h.service.Timeout, func() {
+			cancel(errBackendTimeout)
+		})
+		defer timer.Stop()
+		stopTimeout = timer.Stop
+		r = r.WithContext(ctx)
+	}
+
 	req, err := h.createProxyRequest(r)
 	if err != nil {
-		http.Error(w, "Failed to create proxy request", http.StatusBadRequest)
+		writeError(w, r, "Failed to create proxy request", http.StatusBadRequest)
 		h.errorReporter.ReportError(err)
 		return
 	}
 
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		http.Error(w, "Failed to request for backend", http.StatusBadGateway)
-		h.errorReporter.ReportError(err)
-		return
+	// httputil.ReverseProxy aborts the handler on the error while streaming the response body,
+	// so it reports the error by the writer before that.
+	writer := &errorRecordingResponseWriter{ResponseWriter: w}
+	defer func() {
+		if writer.err != nil {
+			h.errorReporter.ReportError(writer.err)
+		}
+	}()
+
+	// httputil.ReverseProxy hijacks the client connection and splices it to the backend connection
+	// for 101 Switching Protocols response, and closes both when either of them is closed.
+	var upgraded *UpgradedConnection
+	var conn *countingConn
+	proxy := &httputil.ReverseProxy{
+		Rewrite:      rewriteProxyRequest,
+		Transport:    h.transport,
+		ErrorHandler: h.handleError,
+		ErrorLog:     discardLogger,
+		ModifyResponse: func(res *http.Response) error {
+			if stopTimeout != nil && !stopTimeout() {
+				return errBackendTimeout
+			}
+			if res.StatusCode != http.StatusSwitchingProtocols {
+				return nil
+			}
+
+			backConn, ok := res.Body.(io.ReadWriteCloser)
+			if !ok {
+				return nil
+			}
+			conn = &countingConn{ReadWriteCloser: backConn}
+			res.Body = conn
+
+			upgraded = &UpgradedConnection{
+				Service:    h.service.Name,
+				Version:    h.service.Version,
+				Origin:     h.getOrigin(),
+				Protocol:   res.Header.Get("Upgrade"),
+				RemoteAddr: r.RemoteAddr,
+				Path:       r.URL.Path,
+				Opened:     time.Now(),
+			}
+			if h.upgradeHandler != nil {
+				h.upgradeHandler(upgraded)
+			}
+			return nil
+		},
 	}
+	proxy.ServeHTTP(writer, req)
 
-	err = h.proxyResponse(w, res)
-	if err != nil {
-		h.errorReporter.ReportError(err)
+	if upgraded != nil && h.upgradeHandler != nil {
+		upgraded.Closed = time.Now()
+		upgraded.Sent = atomic.LoadInt64(&conn.written)
+		upgraded.Received = atomic.LoadInt64(&conn.read)
+		h.upgradeHandler(upgraded)
 	}
 }
 
+// createProxyRequest creates the request to the origin of the service.
+// httputil.ReverseProxy removes the hop-by-hop headers from it and streams the body.
 func (h *serviceProxyHandler) createProxyRequest(src *http.Request) (*http.Request, error) {
-	u := h.service.Origin.ResolveReference(src.URL)
-	dst, err := http.NewRequest(src.Method, u.String(), src.Body)
+	u := h.getOrigin().ResolveReference(src.URL)
+	dst, err := http.NewRequestWithContext(src.Context(), src.Method, u.String(), src.Body)
 	if err != nil {
 		return nil, err
 	}
@@ -103,42 +349,67 @@ func (h *serviceProxyHandler) createProxyRequest(src *http.Request) (*http.Reque
 	// proxy headers
 	copyHeader(dst.Header, src.Header)
 	dst.Header.Set("X-Forwarded-For", getNewForwardedIPs(src))
-	filterHeaders(dst.Header)
-	if src.ContentLength != -1 {
-		dst.ContentLength = src.ContentLength
+	if src.TLS != nil {
+		// tell the backend that the request is secure like App Engine frontend
+		dst.Header.Set("X-Forwarded-Proto", "https")
+	}
+	for key, values := range h.service.Headers {
+		dst.Header[http.CanonicalHeaderKey(key)] = values
 	}
+	dst.ContentLength = src.ContentLength
+	dst.Trailer = src.Trailer
 
 	return dst, nil
 }
 
-func (h *serviceProxyHandler) proxyResponse(w http.ResponseWriter, res *http.Response) error {
-	defer res.Body.Close()
+func (h *serviceProxyHandler) getOrigin() *url.URL {
+	if h.origin != nil {
+		return h.origin
+	}
+	return h.service.Origin
+}
 
-	// proxy headers
-	filterHeaders(res.Header)
-	copyHeader(w.Header(), res.Header)
-	w.WriteHeader(res.StatusCode)
-
-	// proxy body
-	_, err := io.Copy(w, res.Body)
-	return err
-}
-
-func filterHeaders(h http.Header) {
-	tokens := h[http.CanonicalHeaderKey("Connection")]
-	if tokens != nil && len(tokens) > 0 {
-		for _, token := range tokens {
-			parts := strings.Split(token, ",")
-			for _, part := range parts {
-				trimed := strings.TrimSpace(part)
-				h.Del(trimed)
-			}
+// forwardedHeaders are removed from the outbound request by httputil.ReverseProxy before the rewrite
+var forwardedHeaders = []string{"X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto"}
+
+// rewriteProxyRequest keeps X-Forwarded-* headers given by createProxyRequest
+func rewriteProxyRequest(pr *httputil.ProxyRequest) {
+	for _, key := range forwardedHeaders {
+		if values, ok := pr.In.Header[key]; ok {
+			pr.Out.Header[key] = values
 		}
 	}
+}
+
+// errBackendTimeout is the cause to cancel the request when the backend does not respond in the timeout of the service
+var errBackendTimeout = fmt.Errorf("the backend did not respond in the timeout: %w", context.DeadlineExceeded)
+
+func (h *serviceProxyHandler) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	h.errorReporter.ReportError(err)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(context.Cause(r.Context()), errBackendTimeout) {
+		writeError(w, r, "Timed out to request for backend", http.StatusGatewayTimeout)
+		return
+	}
+	writeError(w, r, "Failed to request for backend", http.StatusBadGateway)
+}
+
+// errorRecordingResponseWriter records the first error on writing the response
+type errorRecordingResponseWriter struct {
+	http.ResponseWriter
+	err error
+}
 
-	for _, key := range nopHeadersByHop {
-		h.Del(key)
+func (w *errorRecordingResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	if err != nil && w.err == nil {
+		w.err = err
 	}
+	return n, err
+}
+
+// Unwrap gives the underlying writer to http.ResponseController to flush the streamed response
+func (w *errorRecordingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
 
 func copyHeader(dst, src http.Header) {
@@ -158,11 +429,9 @@ func getNewForwardedIPs(r *http.Request) string {
 }
 
 func getRemoteIP(r *http.Request) string {
-	// remove port
-	index := strings.Index(r.RemoteAddr, ":")
-	if index == -1 {
-		return r.RemoteAddr
+	// remove port (e.g. "[::1]:5000" for IPv6)
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
 	}
-
-	return r.RemoteAddr[:index]
+	return r.RemoteAddr
 }
@@ -1,17 +1,23 @@
 package gaedispemu
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
 	"reflect"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/MakeNowJust/heredoc"
 	"github.com/google/go-cmp/cmp"
@@ -101,6 +107,9 @@ func TestProxyHandler(t *testing.T) {
 				if s := res.Header.Get("Service"); s != service {
 					t.Errorf("should proxy to %s, but got %s", service, s)
 				}
+				if s, expected := res.Header.Get(DispatchRuleHeader), fmt.Sprintf("*/%s/*", service); !strings.HasSuffix(s, " "+expected) {
+					t.Errorf("should match %s, but got %s", expected, s)
+				}
 
 				body, err := ioutil.ReadAll(res.Body)
 				if err != nil {
@@ -200,7 +209,49 @@ func TestProxyHandler(t *testing.T) {
 		}
 	})
 
+	t.Run("Fallback", func(t *testing.T) {
+		res, err := http.Get(proxy.URL)
+		if err != nil {
+			t.Error(err)
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != 200 {
+			t.Errorf("proxy status code should be 200 but got %d", res.StatusCode)
+		}
+		if s := res.Header.Get("Service"); s != "default" {
+			t.Errorf("should proxy to default, but got %s", s)
+		}
+		if s := res.Header.Get(DispatchRuleHeader); s != "(fallback)" {
+			t.Errorf("should fall back, but got %s", s)
+		}
+	})
+
 	t.Run("NoBackend", func(t *testing.T) {
+		dispatcher, err := NewDispatcher(
+			map[string]*Service{
+				"default": &Service{
+					Name:   "default",
+					Origin: mustParseURL(defaultBackend.URL),
+				},
+			},
+			&Config{
+				Rules: []ConfigRule{
+					{
+						ServiceName:     "default",
+						HostPathMatcher: mustCompileHostPathMatcher("*/default/*"),
+					},
+				},
+			},
+			WithoutFallback(),
+		)
+		if err != nil {
+			t.Error(err)
+		}
+
+		proxy := httptest.NewServer(NewProxyHandler(dispatcher))
+		defer proxy.Close()
+
 		res, err := http.Get(proxy.URL)
 		if err != nil {
 			t.Error(err)
@@ -210,9 +261,116 @@ func TestProxyHandler(t *testing.T) {
 		if res.StatusCode != 404 {
 			t.Errorf("proxy status code should be 404 but got %d", res.StatusCode)
 		}
+		if s := res.Header.Get(DispatchRuleHeader); s != "(no match)" {
+			t.Errorf("should not match, but got %s", s)
+		}
 	})
 }
 
+func TestProxyHandlerHost(t *testing.T) {
+	defaultBackend := httptest.NewServer(getBackendHandler("default"))
+	defer defaultBackend.Close()
+
+	fooBackend := httptest.NewServer(getBackendHandler("foo"))
+	defer fooBackend.Close()
+
+	dispatcher, err := NewDispatcher(
+		map[string]*Service{
+			"default": &Service{
+				Name:   "default",
+				Origin: mustParseURL(defaultBackend.URL),
+			},
+			"foo": &Service{
+				Name:   "foo",
+				Origin: mustParseURL(fooBackend.URL),
+			},
+		},
+		&Config{
+			Rules: []ConfigRule{
+				{
+					ServiceName:     "foo",
+					HostPathMatcher: mustCompileHostPathMatcher("foo.example.com/*"),
+				},
+				{
+					ServiceName:     "default",
+					HostPathMatcher: mustCompileHostPathMatcher("*/*"),
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Error(err)
+	}
+
+	cases := []struct {
+		Name    string
+		Options []ProxyHandlerOption
+		Host    string
+		Header  http.Header
+		Service string
+	}{
+		{
+			Name:    "Host",
+			Host:    "foo.example.com",
+			Service: "foo",
+		},
+		{
+			Name:    "HostWithPort",
+			Host:    "FOO.example.com:3000",
+			Service: "foo",
+		},
+		{
+			Name:    "OtherHost",
+			Host:    "bar.example.com",
+			Service: "default",
+		},
+		{
+			Name:    "IgnoreForwardedHost",
+			Host:    "bar.example.com",
+			Header:  http.Header{"X-Forwarded-Host": {"foo.example.com"}},
+			Service: "default",
+		},
+		{
+			Name:    "ForwardedHost",
+			Options: []ProxyHandlerOption{WithHostHeader("x-forwarded-host")},
+			Host:    "localhost:3000",
+			Header:  http.Header{"X-Forwarded-Host": {"foo.example.com:443, proxy.example.com"}},
+			Service: "foo",
+		},
+		{
+			Name:    "NoForwardedHost",
+			Options: []ProxyHandlerOption{WithHostHeader("X-Forwarded-Host")},
+			Host:    "foo.example.com",
+			Service: "foo",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			proxy := httptest.NewServer(NewProxyHandler(dispatcher, c.Options...))
+			defer proxy.Close()
+
+			req, err := http.NewRequest(http.MethodGet, proxy.URL+"/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Host = c.Host
+			for key, values := range c.Header {
+				req.Header[key] = values
+			}
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			if s := res.Header.Get("Service"); s != c.Service {
+				t.Errorf("should proxy to %s, but got %s", c.Service, s)
+			}
+		})
+	}
+}
+
 func getBackendHandler(service string) http.Handler {
 	replacer := strings.NewReplacer("\r\n", "\n")
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -251,6 +409,24 @@ func (b brokenResponseWriter) Write([]byte) (int, error) {
 	return 0, errors.New("broken")
 }
 
+func TestProxyHandlerNoOrigin(t *testing.T) {
+	dispatcher, err := NewDispatcher(map[string]*Service{"default": {Name: "default"}}, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	NewProxyHandler(dispatcher).ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+
+	result := recorder.Result()
+	if result.StatusCode != http.StatusBadGateway {
+		t.Errorf("Unexpected response status: %d", result.StatusCode)
+	}
+	if body := recorder.Body.String(); body != "No origin for the service: default\n" {
+		t.Errorf("Unexpected response body: %q", body)
+	}
+}
+
 func TestServiceProxyHandler(t *testing.T) {
 	t.Run("FailedToCreateRequest", func(t *testing.T) {
 		var reported []error
@@ -298,6 +474,269 @@ func TestServiceProxyHandler(t *testing.T) {
 		}
 	})
 
+	t.Run("Timeout", func(t *testing.T) {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}))
+		defer backend.Close()
+
+		var reported []error
+		reporter := ErrorReporterFunc(func(err error) {
+			reported = append(reported, err)
+		})
+
+		handler := &serviceProxyHandler{service: &Service{Name: "default", Origin: mustParseURL(backend.URL), Timeout: 10 * time.Millisecond}, errorReporter: reporter}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+
+		if len(reported) != 1 {
+			t.Errorf("Unexpected reported errors: %v", reported)
+		}
+
+		result := recorder.Result()
+		if result.StatusCode != http.StatusGatewayTimeout {
+			t.Errorf("Unexpected response status: %d", result.StatusCode)
+		}
+	})
+
+	t.Run("TimeoutAfterHeaders", func(t *testing.T) {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "foo")
+			http.NewResponseController(w).Flush()
+			time.Sleep(50 * time.Millisecond)
+			io.WriteString(w, "bar")
+		}))
+		defer backend.Close()
+
+		// the timeout is applied only until the response headers
+		handler := &serviceProxyHandler{service: &Service{Name: "default", Origin: mustParseURL(backend.URL), Timeout: 10 * time.Millisecond}, errorReporter: nopErrorReporter}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("Unexpected response status: %d", recorder.Code)
+		}
+		if body := recorder.Body.String(); body != "foobar" {
+			t.Errorf("Unexpected response body: %q", body)
+		}
+	})
+
+	t.Run("Headers", func(t *testing.T) {
+		var received http.Header
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r.Header
+		}))
+		defer backend.Close()
+
+		handler := &serviceProxyHandler{
+			service: &Service{
+				Name:    "default",
+				Origin:  mustParseURL(backend.URL),
+				Headers: http.Header{"X-Appengine-User-Is-Admin": {"1"}, "User-Agent": {"overridden"}},
+			},
+			errorReporter: nopErrorReporter,
+		}
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("User-Agent", "testing")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if v := received.Get("X-Appengine-User-Is-Admin"); v != "1" {
+			t.Errorf("Unexpected X-Appengine-User-Is-Admin: %q", v)
+		}
+		if v := received.Get("User-Agent"); v != "overridden" {
+			t.Errorf("Unexpected User-Agent: %q", v)
+		}
+	})
+
+	t.Run("HopByHopHeaders", func(t *testing.T) {
+		var received http.Header
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r.Header
+		}))
+		defer backend.Close()
+
+		handler := &serviceProxyHandler{service: &Service{Name: "default", Origin: mustParseURL(backend.URL)}, errorReporter: nopErrorReporter}
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Server", "foo")
+		req.Header.Set("Connection", "Keep-Alive, X-Hop")
+		req.Header.Set("Keep-Alive", "timeout=5, max=1000")
+		req.Header.Set("X-Hop", "bar")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if v := received.Get("Server"); v != "foo" {
+			t.Errorf("Unexpected Server: %q", v)
+		}
+		for _, key := range []string{"Keep-Alive", "X-Hop"} {
+			if v, ok := received[key]; ok {
+				t.Errorf("%s should be filtered but got %q", key, v)
+			}
+		}
+	})
+
+	t.Run("ForwardedHeaders", func(t *testing.T) {
+		var received http.Header
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r.Header
+		}))
+		defer backend.Close()
+
+		handler := &serviceProxyHandler{service: &Service{Name: "default", Origin: mustParseURL(backend.URL)}, errorReporter: nopErrorReporter}
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "203.0.113.1:1234"
+		req.Header.Set("X-Forwarded-For", "198.51.100.1")
+		req.Header.Set("X-Forwarded-Host", "example.com")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if v := received.Get("X-Forwarded-For"); v != "203.0.113.1, 198.51.100.1" {
+			t.Errorf("Unexpected X-Forwarded-For: %q", v)
+		}
+		if v := received.Get("X-Forwarded-Host"); v != "example.com" {
+			t.Errorf("Unexpected X-Forwarded-Host: %q", v)
+		}
+		if v, ok := received["X-Forwarded-Proto"]; ok {
+			t.Errorf("Unexpected X-Forwarded-Proto: %q", v)
+		}
+
+		// the request by TLS listener
+		req = httptest.NewRequest("GET", "/", nil)
+		req.TLS = &tls.ConnectionState{}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if v := received.Get("X-Forwarded-Proto"); v != "https" {
+			t.Errorf("Unexpected X-Forwarded-Proto: %q", v)
+		}
+	})
+
+	t.Run("Streaming", func(t *testing.T) {
+		next := make(chan struct{})
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, "data: first\n\n")
+			w.(http.Flusher).Flush()
+
+			<-next
+			io.WriteString(w, "data: second\n\n")
+		}))
+		defer backend.Close()
+
+		proxy := httptest.NewServer(&serviceProxyHandler{service: &Service{Name: "default", Origin: mustParseURL(backend.URL)}, errorReporter: nopErrorReporter})
+		defer proxy.Close()
+
+		res, err := http.Get(proxy.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		// the first event should arrive before the backend finishes the response
+		reader := bufio.NewReader(res.Body)
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != "data: first\n" {
+			t.Errorf("Unexpected event: %q", line)
+		}
+		close(next)
+
+		rest, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(rest) != "\ndata: second\n\n" {
+			t.Errorf("Unexpected events: %q", rest)
+		}
+	})
+
+	t.Run("Trailers", func(t *testing.T) {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Trailer", "X-Checksum")
+			io.WriteString(w, "body")
+			w.Header().Set("X-Checksum", "abc")
+		}))
+		defer backend.Close()
+
+		proxy := httptest.NewServer(&serviceProxyHandler{service: &Service{Name: "default", Origin: mustParseURL(backend.URL)}, errorReporter: nopErrorReporter})
+		defer proxy.Close()
+
+		res, err := http.Get(proxy.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if _, err := ioutil.ReadAll(res.Body); err != nil {
+			t.Fatal(err)
+		}
+		if v := res.Trailer.Get("X-Checksum"); v != "abc" {
+			t.Errorf("Unexpected trailer: %q", v)
+		}
+	})
+
+	t.Run("InformationalResponse", func(t *testing.T) {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Link", "</style.css>; rel=preload")
+			w.WriteHeader(http.StatusEarlyHints)
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer backend.Close()
+
+		proxy := httptest.NewServer(&serviceProxyHandler{service: &Service{Name: "default", Origin: mustParseURL(backend.URL)}, errorReporter: nopErrorReporter})
+		defer proxy.Close()
+
+		var informational []int
+		trace := &httptrace.ClientTrace{
+			Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+				informational = append(informational, code)
+				return nil
+			},
+		}
+		req, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), "GET", proxy.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if diff := cmp.Diff([]int{http.StatusEarlyHints}, informational); diff != "" {
+			t.Errorf("Unexpected informational responses: %s", diff)
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		received := make(chan struct{})
+		canceled := make(chan struct{})
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(received)
+			<-r.Context().Done()
+			close(canceled)
+		}))
+		defer backend.Close()
+
+		proxy := httptest.NewServer(&serviceProxyHandler{service: &Service{Name: "default", Origin: mustParseURL(backend.URL)}, errorReporter: nopErrorReporter})
+		defer proxy.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		req, err := http.NewRequestWithContext(ctx, "GET", proxy.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			<-received
+			cancel()
+		}()
+		if _, err := http.DefaultClient.Do(req); err == nil {
+			t.Error("should be error")
+		}
+
+		select {
+		case <-canceled:
+		case <-time.After(5 * time.Second):
+			t.Error("the backend request should be canceled")
+		}
+	})
+
 	t.Run("FailedToWriteResponse", func(t *testing.T) {
 		defaultBackend := httptest.NewServer(getBackendHandler("default"))
 		defer defaultBackend.Close()
@@ -340,32 +779,21 @@ func TestCreateProxyRequest(t *testing.T) {
 	})
 }
 
-func TestFilterHeaders(t *testing.T) {
-	t.Run("Keep", func(t *testing.T) {
-		h := http.Header{}
-		h.Set("Server", "foo")
-		filterHeaders(h)
-
-		expected := http.Header{}
-		expected.Set("Server", "foo")
-		if diff := cmp.Diff(expected, h); diff != "" {
-			t.Errorf("should not be filterd any fields but got %s", diff)
-		}
-	})
-
-	t.Run("Connection", func(t *testing.T) {
-		h := http.Header{}
-		h.Set("Server", "foo")
-		h.Set("Connection", "Keep-Alive")
-		h.Set("Keep-Alive", "timeout=5, max=1000")
-		filterHeaders(h)
-
-		expected := http.Header{}
-		expected.Set("Server", "foo")
-		if diff := cmp.Diff(expected, h); diff != "" {
-			t.Errorf("should be filterd some fields but got %s", diff)
+func TestNormalizeHost(t *testing.T) {
+	cases := map[string]string{
+		"example.com":      "example.com",
+		"Example.COM":      "example.com",
+		"example.com:3000": "example.com",
+		"example.com.":     "example.com",
+		"127.0.0.1:3000":   "127.0.0.1",
+		"[::1]:3000":       "::1",
+		"":                 "",
+	}
+	for host, expected := range cases {
+		if got := normalizeHost(host); got != expected {
+			t.Errorf("should be %q for %q but got %q", expected, host, got)
 		}
-	})
+	}
 }
 
 func TestGetRemoteIP(t *testing.T) {
@@ -376,4 +804,8 @@ func TestGetRemoteIP(t *testing.T) {
 	if ip := getRemoteIP(&http.Request{RemoteAddr: "203.0.113.1:12345"}); ip != "203.0.113.1" {
 		t.Errorf("should be 203.0.113.1 but got %s", ip)
 	}
+
+	if ip := getRemoteIP(&http.Request{RemoteAddr: "[2001:db8::1]:12345"}); ip != "2001:db8::1" {
+		t.Errorf("should be 2001:db8::1 but got %s", ip)
+	}
 }
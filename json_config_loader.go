@@ -0,0 +1,164 @@
+package gaedispemu
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// JSONConfigLoader is a config loader for the dispatch rules of App Engine Admin API.
+// It accepts the body of apps.patch ({"dispatchRules": [{"domain": ..., "path": ..., "service": ...}]}),
+// the output of `gcloud app describe --format=json`, or the bare array of the dispatch rules.
+type JSONConfigLoader struct {
+	filePath string
+}
+
+var (
+	_ ConfigLoader    = (*JSONConfigLoader)(nil)
+	_ ConfigValidator = (*JSONConfigLoader)(nil)
+)
+
+// NewJSONConfigLoader is constructor of JSONConfigLoader
+func NewJSONConfigLoader(filePath string) *JSONConfigLoader {
+	return &JSONConfigLoader{filePath: filePath}
+}
+
+// LoadConfig loads and parse the dispatch rules JSON
+func (l *JSONConfigLoader) LoadConfig() (*Config, error) {
+	raw, err := l.loadRawConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return raw.compile()
+}
+
+// ValidateConfig loads and validate the dispatch rules JSON
+func (l *JSONConfigLoader) ValidateConfig() error {
+	raw, err := l.loadRawConfig()
+	if err != nil {
+		return err
+	}
+
+	return raw.validate()
+}
+
+func (l *JSONConfigLoader) loadRawConfig() (*rawConfig, error) {
+	data, err := os.ReadFile(l.filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('['):
+		return l.parseRules(decoder, data)
+	case json.Delim('{'):
+		var config *rawConfig
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			// other fields of the application resource are not interested
+			if key != "dispatchRules" {
+				var skip json.RawMessage
+				if err := decoder.Decode(&skip); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			line := getLine(data, decoder.InputOffset())
+			token, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			if token != json.Delim('[') {
+				return nil, fmt.Errorf("json: line %d: dispatchRules should be an array", line)
+			}
+
+			config, err = l.parseRules(decoder, data)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if config == nil {
+			return nil, fmt.Errorf("json: line %d: dispatchRules is not found", getLine(data, 0))
+		}
+		return config, nil
+	default:
+		return nil, fmt.Errorf("json: line %d: dispatch rules should be an object or an array", getLine(data, 0))
+	}
+}
+
+// parseRules parses the dispatch rules after the beginning of the array
+func (l *JSONConfigLoader) parseRules(decoder *json.Decoder, data []byte) (*rawConfig, error) {
+	config := &rawConfig{}
+	for decoder.More() {
+		line := getLine(data, decoder.InputOffset())
+
+		var entry map[string]json.RawMessage
+		if err := decoder.Decode(&entry); err != nil {
+			return nil, err
+		}
+
+		rule := rawConfigRule{Line: line}
+		var domain, path string
+		keys := make([]string, 0, len(entry))
+		for key := range entry {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			var field *string
+			switch key {
+			case "domain":
+				field = &domain
+			case "path":
+				field = &path
+			case "service":
+				field = &rule.ServiceName
+			default:
+				config.Problems = append(config.Problems, &ValidationError{Line: line, Message: fmt.Sprintf("unknown key: %s", key)})
+				continue
+			}
+
+			if err := json.Unmarshal(entry[key], field); err != nil {
+				return nil, fmt.Errorf("json: line %d: %s should be a string", line, key)
+			}
+		}
+
+		if domain != "" || path != "" {
+			if !strings.HasPrefix(path, "/") {
+				path = "/" + path
+			}
+			rule.URL = domain + path
+		}
+		config.Rules = append(config.Rules, rule)
+	}
+
+	// the end of the array
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// getLine returns the line number of the next token from the offset
+func getLine(data []byte, offset int64) int {
+	for offset < int64(len(data)) && strings.IndexByte(" \t\r\n,:", data[offset]) != -1 {
+		offset++
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
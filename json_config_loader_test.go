@@ -0,0 +1,92 @@
+package gaedispemu
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJSONConfigLoader(t *testing.T) {
+	for _, configFile := range []string{"./testdata/dispatch.json", "./testdata/app.json"} {
+		loader := NewJSONConfigLoader(configFile)
+		config, err := loader.LoadConfig()
+		if err != nil {
+			t.Error(err)
+		}
+
+		testConfig(t, config)
+	}
+
+	t.Run("Array", func(t *testing.T) {
+		f, err := ioutil.TempFile("", "dispatch*.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+
+		io.WriteString(f, `[{"domain": "*", "path": "/mobile/*", "service": "mobile-frontend"}]`)
+		f.Close()
+
+		config, err := NewJSONConfigLoader(f.Name()).LoadConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Len() != 1 {
+			t.Fatalf("should have 1 rule but got %d rules", config.Len())
+		}
+		if rule := config.Rules[0]; rule.ServiceName != "mobile-frontend" || rule.HostPathMatcher.(PatternMatcher).String() != "*/mobile/*" {
+			t.Errorf("unexpected rule: %s (service: %s)", rule, rule.ServiceName)
+		}
+	})
+}
+
+func TestJSONConfigLoaderError(t *testing.T) {
+	_, err := NewJSONConfigLoader("./testdata/naiyo-dispatch.json").LoadConfig()
+	if err == nil {
+		t.Error("should be error")
+	}
+
+	_, err = NewJSONConfigLoader("./testdata/dispatch.yaml").LoadConfig()
+	if err == nil {
+		t.Error("should be error")
+	}
+
+	_, err = NewJSONConfigLoader("./testdata/invalid-dispatch.json").LoadConfig()
+	if err == nil {
+		t.Error("should be error")
+	}
+
+	_, err = NewJSONConfigLoader("./testdata/no-dispatch-rules.json").LoadConfig()
+	if err == nil {
+		t.Error("should be error")
+	} else if s := err.Error(); s != "json: line 1: dispatchRules is not found" {
+		t.Errorf("unexpected error: %s", s)
+	}
+}
+
+func TestJSONConfigLoaderValidate(t *testing.T) {
+	err := NewJSONConfigLoader("./testdata/dispatch.json").ValidateConfig()
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = NewJSONConfigLoader("./testdata/problematic-dispatch.json").ValidateConfig()
+	expected := ValidationErrors{
+		{Line: 4, Message: "Invalid URL pattern: */mob*ile/* (Invalid Path Pattern)"},
+		{Line: 5, Message: `duplicated rule: "*/favicon.ico" is already defined at line 3`},
+		{Line: 6, Message: "unknown key: module"},
+		{Line: 6, Message: "service is required"},
+		{Line: 7, Message: "url pattern is too long: 102 characters (up to 100 characters)"},
+	}
+	if diff := cmp.Diff(expected, err); diff != "" {
+		t.Errorf("unexpected validation errors: %s", diff)
+	}
+
+	err = NewJSONConfigLoader("./testdata/dispatch.xml").ValidateConfig()
+	if _, ok := err.(ValidationErrors); ok || err == nil {
+		t.Errorf("should be parse error but got: %v", err)
+	}
+}
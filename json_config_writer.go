@@ -0,0 +1,44 @@
+package gaedispemu
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// JSONConfigWriter is a config writer for the dispatch rules of App Engine Admin API.
+// It writes the body of apps.patch ({"dispatchRules": [{"domain": ..., "path": ..., "service": ...}]}).
+// The comments are dropped because JSON has no comments.
+type JSONConfigWriter struct{}
+
+var _ ConfigWriter = (*JSONConfigWriter)(nil)
+
+// NewJSONConfigWriter is constructor of JSONConfigWriter
+func NewJSONConfigWriter() *JSONConfigWriter {
+	return &JSONConfigWriter{}
+}
+
+type jsonDispatchRule struct {
+	Domain  string `json:"domain"`
+	Path    string `json:"path"`
+	Service string `json:"service"`
+}
+
+// WriteConfig writes the config as dispatch rules JSON
+func (w *JSONConfigWriter) WriteConfig(out io.Writer, config *Config) error {
+	rules := make([]jsonDispatchRule, len(config.Rules))
+	for i, rule := range config.Rules {
+		m, err := rule.patternMatcher()
+		if err != nil {
+			return err
+		}
+
+		domain, path := splitPattern(m.String())
+		rules[i] = jsonDispatchRule{Domain: domain, Path: path, Service: rule.ServiceName}
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		DispatchRules []jsonDispatchRule `json:"dispatchRules"`
+	}{DispatchRules: rules})
+}
@@ -0,0 +1,29 @@
+package gaedispemu
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJSONConfigWriter(t *testing.T) {
+	config, err := NewYAMLConfigLoader("./testdata/dispatch.yaml").LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := NewJSONConfigWriter().WriteConfig(&buf, config); err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := ioutil.ReadFile("./testdata/dispatch.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(expected), buf.String()); diff != "" {
+		t.Errorf("unexpected JSON: %s", diff)
+	}
+}
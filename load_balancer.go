@@ -0,0 +1,146 @@
+package gaedispemu
+
+import (
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// BalancingRoundRobin chooses the instances in turn
+	BalancingRoundRobin = "round-robin"
+	// BalancingRandom chooses the instance at random
+	BalancingRandom = "random"
+	// BalancingLeastConnections chooses the instance which has the fewest requests in flight
+	BalancingLeastConnections = "least-connections"
+)
+
+// AffinityCookiePrefix is the prefix of the cookie name to keep the instance of the service for the client
+const AffinityCookiePrefix = "GAEDISPEMU_AFFINITY_"
+
+// loadBalancer chooses the instance of the service
+type loadBalancer struct {
+	service *Service
+	origins []*url.URL
+
+	mu     sync.Mutex
+	next   int
+	active []int
+	rand   *rand.Rand
+}
+
+func newLoadBalancer(service *Service) *loadBalancer {
+	origins := service.Instances()
+	return &loadBalancer{
+		service: service,
+		origins: origins,
+		active:  make([]int, len(origins)),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// acquire chooses the healthy instance for the request, and the returned function must be called after the request.
+// healthy tells whether the instance is healthy (nil if all instances are healthy).
+// It sets the affinity cookie to the response if the service has the affinity.
+func (b *loadBalancer) acquire(w http.ResponseWriter, r *http.Request, healthy func(origin *url.URL) bool) (*url.URL, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	available := b.availableInstances(healthy)
+	index := -1
+	if b.service.Affinity {
+		index = b.affinityInstance(r)
+		if index != -1 && !available[index] {
+			index = -1
+		}
+	}
+	if index == -1 {
+		index = b.choose(available)
+		if b.service.Affinity {
+			http.SetCookie(w, &http.Cookie{Name: b.cookieName(), Value: strconv.Itoa(index), Path: "/", HttpOnly: true})
+		}
+	}
+
+	b.active[index]++
+	released := false
+	return b.origins[index], func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if !released {
+			released = true
+			b.active[index]--
+		}
+	}
+}
+
+// availableInstances returns which instances can be chosen.
+// All instances are available if none of them are healthy to try them anyway.
+func (b *loadBalancer) availableInstances(healthy func(origin *url.URL) bool) []bool {
+	available := make([]bool, len(b.origins))
+	found := false
+	for i, origin := range b.origins {
+		available[i] = healthy == nil || healthy(origin)
+		found = found || available[i]
+	}
+	if !found {
+		for i := range available {
+			available[i] = true
+		}
+	}
+	return available
+}
+
+func (b *loadBalancer) choose(available []bool) int {
+	switch b.service.Balancing {
+	case BalancingRandom:
+		var indexes []int
+		for i, ok := range available {
+			if ok {
+				indexes = append(indexes, i)
+			}
+		}
+		return indexes[b.rand.Intn(len(indexes))]
+	case BalancingLeastConnections:
+		index := -1
+		for i, n := range b.active {
+			if available[i] && (index == -1 || n < b.active[index]) {
+				index = i
+			}
+		}
+		return index
+	default:
+		for {
+			index := b.next
+			b.next = (b.next + 1) % len(b.origins)
+			if available[index] {
+				return index
+			}
+		}
+	}
+}
+
+// cookieName returns the name of the affinity cookie (e.g. GAEDISPEMU_AFFINITY_default, GAEDISPEMU_AFFINITY_default_v1 for the version)
+func (b *loadBalancer) cookieName() string {
+	if b.service.Version != "" {
+		return AffinityCookiePrefix + b.service.Name + "_" + b.service.Version
+	}
+	return AffinityCookiePrefix + b.service.Name
+}
+
+// affinityInstance returns the instance in the affinity cookie (-1 if no valid cookie)
+func (b *loadBalancer) affinityInstance(r *http.Request) int {
+	cookie, err := r.Cookie(b.cookieName())
+	if err != nil {
+		return -1
+	}
+
+	index, err := strconv.Atoi(cookie.Value)
+	if err != nil || index < 0 || len(b.origins) <= index {
+		return -1
+	}
+	return index
+}
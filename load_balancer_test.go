@@ -0,0 +1,201 @@
+package gaedispemu
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func newTestInstances() []*url.URL {
+	return []*url.URL{
+		mustParseURL("http://localhost:8081"),
+		mustParseURL("http://localhost:8082"),
+		mustParseURL("http://localhost:8083"),
+	}
+}
+
+func TestLoadBalancer(t *testing.T) {
+	t.Run("RoundRobin", func(t *testing.T) {
+		b := newLoadBalancer(&Service{Name: "default", Origins: newTestInstances()})
+
+		var hosts []string
+		for i := 0; i < 4; i++ {
+			origin, release := b.acquire(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), nil)
+			hosts = append(hosts, origin.Host)
+			release()
+		}
+		if diff := cmp.Diff([]string{"localhost:8081", "localhost:8082", "localhost:8083", "localhost:8081"}, hosts); diff != "" {
+			t.Errorf("unexpected instances: %s", diff)
+		}
+	})
+
+	t.Run("LeastConnections", func(t *testing.T) {
+		b := newLoadBalancer(&Service{Name: "default", Origins: newTestInstances(), Balancing: BalancingLeastConnections})
+
+		first, releaseFirst := b.acquire(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), nil)
+		second, releaseSecond := b.acquire(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), nil)
+		releaseFirst()
+		releaseFirst() // released only once
+		third, releaseThird := b.acquire(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), nil)
+		defer releaseSecond()
+		defer releaseThird()
+
+		if first.Host != "localhost:8081" || second.Host != "localhost:8082" || third.Host != "localhost:8081" {
+			t.Errorf("unexpected instances: %s, %s, %s", first.Host, second.Host, third.Host)
+		}
+	})
+
+	t.Run("Random", func(t *testing.T) {
+		b := newLoadBalancer(&Service{Name: "default", Origins: newTestInstances(), Balancing: BalancingRandom})
+
+		seen := map[string]bool{}
+		for i := 0; i < 100; i++ {
+			origin, release := b.acquire(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), nil)
+			seen[origin.Host] = true
+			release()
+		}
+		if len(seen) != 3 {
+			t.Errorf("should choose all instances: %v", seen)
+		}
+	})
+
+	t.Run("Affinity", func(t *testing.T) {
+		b := newLoadBalancer(&Service{Name: "default", Origins: newTestInstances(), Affinity: true})
+
+		recorder := httptest.NewRecorder()
+		origin, release := b.acquire(recorder, httptest.NewRequest("GET", "/", nil), nil)
+		release()
+		cookies := recorder.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != AffinityCookiePrefix+"default" || cookies[0].Value != "0" {
+			t.Fatalf("unexpected cookies: %v", cookies)
+		}
+
+		for i := 0; i < 3; i++ {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.AddCookie(cookies[0])
+			recorder := httptest.NewRecorder()
+			sticky, release := b.acquire(recorder, req, nil)
+			release()
+			if sticky.Host != origin.Host {
+				t.Errorf("should stick to %s, but got %s", origin.Host, sticky.Host)
+			}
+			if len(recorder.Result().Cookies()) != 0 {
+				t.Error("should not set the cookie again")
+			}
+		}
+
+		// the invalid cookie is ignored
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: AffinityCookiePrefix + "default", Value: "3"})
+		recorder = httptest.NewRecorder()
+		if next, release := b.acquire(recorder, req, nil); next.Host != "localhost:8082" {
+			t.Errorf("unexpected instance: %s", next.Host)
+		} else {
+			release()
+		}
+		if len(recorder.Result().Cookies()) != 1 {
+			t.Error("should set the cookie again")
+		}
+	})
+
+	t.Run("VersionAffinity", func(t *testing.T) {
+		b := newLoadBalancer(&Service{Name: "default", Version: "v1", Origins: newTestInstances(), Affinity: true})
+
+		// the cookie of the service is not for the version
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: AffinityCookiePrefix + "default", Value: "2"})
+		recorder := httptest.NewRecorder()
+		origin, release := b.acquire(recorder, req, nil)
+		release()
+		if origin.Host != "localhost:8081" {
+			t.Errorf("unexpected instance: %s", origin.Host)
+		}
+		cookies := recorder.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != AffinityCookiePrefix+"default_v1" {
+			t.Errorf("unexpected cookies: %v", cookies)
+		}
+	})
+
+	t.Run("Unhealthy", func(t *testing.T) {
+		// localhost:8082 is unhealthy
+		healthy := func(origin *url.URL) bool {
+			return origin.Host != "localhost:8082"
+		}
+		for _, balancing := range []string{BalancingRoundRobin, BalancingRandom, BalancingLeastConnections} {
+			b := newLoadBalancer(&Service{Name: "default", Origins: newTestInstances(), Balancing: balancing})
+			for i := 0; i < 10; i++ {
+				origin, release := b.acquire(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), healthy)
+				release()
+				if origin.Host == "localhost:8082" {
+					t.Errorf("%s: should skip the unhealthy instance", balancing)
+				}
+			}
+		}
+
+		// the affinity to the unhealthy instance is ignored
+		b := newLoadBalancer(&Service{Name: "default", Origins: newTestInstances(), Affinity: true})
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: AffinityCookiePrefix + "default", Value: "1"})
+		recorder := httptest.NewRecorder()
+		origin, release := b.acquire(recorder, req, healthy)
+		release()
+		if origin.Host != "localhost:8081" {
+			t.Errorf("unexpected instance: %s", origin.Host)
+		}
+		if len(recorder.Result().Cookies()) != 1 {
+			t.Error("should set the cookie again")
+		}
+
+		// all instances are chosen if none of them are healthy
+		b = newLoadBalancer(&Service{Name: "default", Origins: newTestInstances()})
+		origin, release = b.acquire(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), func(*url.URL) bool { return false })
+		release()
+		if origin.Host != "localhost:8081" {
+			t.Errorf("unexpected instance: %s", origin.Host)
+		}
+	})
+}
+
+func TestProxyHandlerAffinity(t *testing.T) {
+	backends := make([]*httptest.Server, 2)
+	origins := make([]*url.URL, 2)
+	for i, name := range []string{"first", "second"} {
+		backends[i] = httptest.NewServer(getBackendHandler(name))
+		defer backends[i].Close()
+		origins[i] = mustParseURL(backends[i].URL)
+	}
+
+	service := &Service{Name: "default", Affinity: true}
+	service.SetOrigins(origins)
+	dispatcher, err := NewDispatcher(map[string]*Service{"default": service}, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewProxyHandler(dispatcher)
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Status", "200")
+	handler.ServeHTTP(recorder, req)
+	res := recorder.Result()
+	if s := res.Header.Get("Service"); s != "first" {
+		t.Errorf("should proxy to first, but got %s", s)
+	}
+	if cookies := res.Cookies(); len(cookies) != 1 || cookies[0].Name != AffinityCookiePrefix+"default" {
+		t.Fatalf("unexpected cookies: %v", cookies)
+	}
+
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Status", "200")
+		req.AddCookie(res.Cookies()[0])
+		handler.ServeHTTP(recorder, req)
+		if s := recorder.Result().Header.Get("Service"); s != "first" {
+			t.Errorf("should stick to first, but got %s", s)
+		}
+	}
+}
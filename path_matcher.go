@@ -14,23 +14,24 @@ var errInvalidPathPattern = errors.New("Invalid Path Pattern")
 
 var pathPatternRegexp = regexp.MustCompile(`^[^\*]+\*?$`)
 
-func compilePathMatcher(pattern string) (pathMatcher, error) {
+// compilePathMatcher compiles the path pattern without the leading slash
+func compilePathMatcher(pattern string) (pathMatcher, PatternPart, error) {
 	if pattern == "" || pattern == "*" {
-		return passThroughPathMatcher, nil
+		return passThroughPathMatcher, PatternPart{Pattern: "/" + pattern, Wildcard: WildcardAny, Value: "/"}, nil
 	}
 
 	if !pathPatternRegexp.MatchString(pattern) {
-		return nil, errInvalidPathPattern
+		return nil, PatternPart{}, errInvalidPathPattern
 	}
 
 	if pattern[len(pattern)-1] == '*' {
 		prefix := pattern[0 : len(pattern)-1]
 		matcher := prefixStringMathcer{prefix: prefix}
-		return &stringPathMatcher{stringMatcher: matcher}, nil
+		return &stringPathMatcher{stringMatcher: matcher}, PatternPart{Pattern: "/" + pattern, Wildcard: WildcardPrefix, Value: "/" + prefix}, nil
 	}
 
 	matcher := justStringMathcer{expected: pattern}
-	return &stringPathMatcher{stringMatcher: matcher}, nil
+	return &stringPathMatcher{stringMatcher: matcher}, PatternPart{Pattern: "/" + pattern, Wildcard: WildcardNone, Value: "/" + pattern}, nil
 }
 
 type passThroughPathMatcherType struct{}
@@ -0,0 +1,60 @@
+package gaedispemu
+
+import "strings"
+
+// WildcardKind is a kind of wildcard in the host or path part of URL pattern
+type WildcardKind int
+
+const (
+	// WildcardNone means the part matches exactly (e.g. "example.com", "/favicon.ico")
+	WildcardNone WildcardKind = iota
+	// WildcardAny means the part matches anything (e.g. "*", "/*")
+	WildcardAny
+	// WildcardPrefix means the part matches by the prefix (e.g. "/mobile/*")
+	WildcardPrefix
+	// WildcardSuffix means the part matches by the suffix (e.g. "*.example.com")
+	WildcardSuffix
+)
+
+func (k WildcardKind) String() string {
+	switch k {
+	case WildcardNone:
+		return "none"
+	case WildcardAny:
+		return "any"
+	case WildcardPrefix:
+		return "prefix"
+	case WildcardSuffix:
+		return "suffix"
+	default:
+		return "unknown"
+	}
+}
+
+// PatternPart is a host or path part of URL pattern
+type PatternPart struct {
+	// Pattern is the original text of the part (e.g. "*.example.com", "/mobile/*")
+	Pattern string
+	// Wildcard is the kind of wildcard in the part
+	Wildcard WildcardKind
+	// Value is the text without the wildcard (e.g. ".example.com", "/mobile/")
+	Value string
+}
+
+func (p PatternPart) String() string {
+	return p.Pattern
+}
+
+// Covers returns true if the part matches all of the strings the other part matches
+func (p PatternPart) Covers(other PatternPart) bool {
+	switch p.Wildcard {
+	case WildcardAny:
+		return true
+	case WildcardPrefix:
+		return (other.Wildcard == WildcardNone || other.Wildcard == WildcardPrefix) && strings.HasPrefix(other.Value, p.Value)
+	case WildcardSuffix:
+		return (other.Wildcard == WildcardNone || other.Wildcard == WildcardSuffix) && strings.HasSuffix(other.Value, p.Value)
+	default:
+		return other.Wildcard == WildcardNone && other.Value == p.Value
+	}
+}
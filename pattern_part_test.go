@@ -0,0 +1,49 @@
+package gaedispemu
+
+import "testing"
+
+func TestWildcardKind(t *testing.T) {
+	cases := map[WildcardKind]string{
+		WildcardNone:     "none",
+		WildcardAny:      "any",
+		WildcardPrefix:   "prefix",
+		WildcardSuffix:   "suffix",
+		WildcardKind(-1): "unknown",
+	}
+	for kind, expected := range cases {
+		if s := kind.String(); s != expected {
+			t.Errorf("should be %s but got %s", expected, s)
+		}
+	}
+}
+
+func TestPatternPartCovers(t *testing.T) {
+	anything := PatternPart{Pattern: "*", Wildcard: WildcardAny}
+	exact := PatternPart{Pattern: "/mobile/index.html", Wildcard: WildcardNone, Value: "/mobile/index.html"}
+	prefix := PatternPart{Pattern: "/mobile/*", Wildcard: WildcardPrefix, Value: "/mobile/"}
+	shortPrefix := PatternPart{Pattern: "/mob*", Wildcard: WildcardPrefix, Value: "/mob"}
+	suffix := PatternPart{Pattern: "*.example.com", Wildcard: WildcardSuffix, Value: ".example.com"}
+	host := PatternPart{Pattern: "foo.example.com", Wildcard: WildcardNone, Value: "foo.example.com"}
+
+	cases := []struct {
+		A, B     PatternPart
+		Expected bool
+	}{
+		{A: anything, B: anything, Expected: true},
+		{A: anything, B: prefix, Expected: true},
+		{A: prefix, B: anything, Expected: false},
+		{A: prefix, B: exact, Expected: true},
+		{A: exact, B: prefix, Expected: false},
+		{A: exact, B: exact, Expected: true},
+		{A: shortPrefix, B: prefix, Expected: true},
+		{A: prefix, B: shortPrefix, Expected: false},
+		{A: suffix, B: host, Expected: true},
+		{A: host, B: suffix, Expected: false},
+		{A: suffix, B: prefix, Expected: false},
+	}
+	for _, c := range cases {
+		if got := c.A.Covers(c.B); got != c.Expected {
+			t.Errorf("%s covers %s should be %v", c.A, c.B, c.Expected)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package gaedispemu
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+var procfileLine = regexp.MustCompile(`^([A-Za-z0-9_-]+):\s*(.+)$`)
+
+// LoadProcfile loads the commands of the services from Procfile (e.g. "mobile-frontend: go run ./mobile -port $PORT")
+func LoadProcfile(filePath string) (map[string]string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	commands := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if text == "" || text[0] == '#' {
+			continue
+		}
+
+		m := procfileLine.FindStringSubmatch(text)
+		if m == nil {
+			return nil, fmt.Errorf("procfile: line %d: invalid format: %s", line, text)
+		}
+		if _, ok := commands[m[1]]; ok {
+			return nil, fmt.Errorf("procfile: line %d: duplicated process name: %s", line, m[1])
+		}
+		commands[m[1]] = m[2]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return commands, nil
+}
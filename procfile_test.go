@@ -0,0 +1,54 @@
+package gaedispemu
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadProcfile(t *testing.T) {
+	commands, err := LoadProcfile("./testdata/Procfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"default":         "dev_appserver.py --port=$PORT default/app.yaml",
+		"mobile-frontend": "go run ./mobile -port $PORT",
+	}
+	if diff := cmp.Diff(expected, commands); diff != "" {
+		t.Errorf("unexpected commands: %s", diff)
+	}
+}
+
+func TestLoadProcfileError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gaedispemu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := map[string]string{
+		"invalid":    "default dev_appserver.py\n",
+		"duplicated": "default: foo\ndefault: bar\n",
+	}
+	for name, content := range cases {
+		t.Run(name, func(t *testing.T) {
+			filePath := filepath.Join(dir, name)
+			if err := ioutil.WriteFile(filePath, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := LoadProcfile(filePath); err == nil {
+				t.Error("should be error")
+			}
+		})
+	}
+
+	if _, err := LoadProcfile("./testdata/naiyo-Procfile"); err == nil {
+		t.Error("should be error")
+	}
+}
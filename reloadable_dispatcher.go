@@ -0,0 +1,133 @@
+package gaedispemu
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReloadHandler is called after reloading the config.
+// next is nil and the previous rules are still used if err is not nil.
+type ReloadHandler func(prev, next *Config, err error)
+
+// ReloadableDispatcher is a dispatcher which can reload the config without restarting
+type ReloadableDispatcher struct {
+	loader   ConfigLoader
+	services map[string]*Service
+	opts     []DispatcherOption
+
+	mu      sync.Mutex
+	current atomic.Value // *dispatcherSnapshot
+}
+
+type dispatcherSnapshot struct {
+	config     *Config
+	dispatcher Dispatcher
+}
+
+var (
+	_ Dispatcher        = (*ReloadableDispatcher)(nil)
+	_ Resolver          = (*ReloadableDispatcher)(nil)
+	_ RequestDispatcher = (*ReloadableDispatcher)(nil)
+)
+
+// NewReloadableDispatcher is a constructor of ReloadableDispatcher
+func NewReloadableDispatcher(loader ConfigLoader, services map[string]*Service, opts ...DispatcherOption) (*ReloadableDispatcher, error) {
+	d := &ReloadableDispatcher{loader: loader, services: services, opts: opts}
+	snapshot, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+
+	d.current.Store(snapshot)
+	return d, nil
+}
+
+func (d *ReloadableDispatcher) load() (*dispatcherSnapshot, error) {
+	config, err := d.loader.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dispatcher, err := NewDispatcher(d.services, config, d.opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dispatcherSnapshot{config: config, dispatcher: dispatcher}, nil
+}
+
+func (d *ReloadableDispatcher) snapshot() *dispatcherSnapshot {
+	return d.current.Load().(*dispatcherSnapshot)
+}
+
+// Dispatch dispatches by the current rules
+func (d *ReloadableDispatcher) Dispatch(host, path string) *Service {
+	return d.snapshot().dispatcher.Dispatch(host, path)
+}
+
+// Resolve dispatches by the current rules and returns how the service is chosen
+func (d *ReloadableDispatcher) Resolve(host, path string) *DispatchResult {
+	return Resolve(d.snapshot().dispatcher, host, path)
+}
+
+// DispatchRequest dispatches the request by the current rules and returns how the service is chosen
+func (d *ReloadableDispatcher) DispatchRequest(r *http.Request) *DispatchResult {
+	return d.Resolve(getRequestHost(r), r.URL.Path)
+}
+
+// Config returns the current config
+func (d *ReloadableDispatcher) Config() *Config {
+	return d.snapshot().config
+}
+
+// Reload loads the config again and swaps the rules atomically.
+// It keeps the current rules if the new config is broken.
+func (d *ReloadableDispatcher) Reload() (prev, next *Config, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	prev = d.Config()
+	snapshot, err := d.load()
+	if err != nil {
+		return prev, nil, err
+	}
+
+	d.current.Store(snapshot)
+	return prev, snapshot.config, nil
+}
+
+// Watch reloads the config every time the file is modified until the context is done.
+// The file is checked by polling its modification time and size with the interval
+// because editors often replace the file rather than writing it in place.
+func (d *ReloadableDispatcher) Watch(ctx context.Context, filePath string, interval time.Duration, handler ReloadHandler) {
+	last, _ := os.Stat(filePath)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		stat, err := os.Stat(filePath)
+		if err != nil {
+			// the file may be in the middle of being replaced
+			continue
+		}
+		if last != nil && stat.ModTime().Equal(last.ModTime()) && stat.Size() == last.Size() {
+			continue
+		}
+
+		last = stat
+		prev, next, err := d.Reload()
+		if handler != nil {
+			handler(prev, next, err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package gaedispemu
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MakeNowJust/heredoc"
+)
+
+func TestReloadableDispatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gaedispemu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configFile := filepath.Join(dir, "dispatch.yaml")
+	// replace the file by renaming not to be read in the middle of writing by the watcher
+	writeConfig := func(content string) {
+		tmpFile := configFile + ".tmp"
+		if err := ioutil.WriteFile(tmpFile, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmpFile, configFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(heredoc.Doc(`
+		dispatch:
+		  - url: "*/foo/*"
+		    service: foo
+	`))
+
+	services := map[string]*Service{
+		"default": &Service{
+			Name:   "default",
+			Origin: mustParseURL("http://localhost:8081"),
+		},
+		"foo": &Service{
+			Name:   "foo",
+			Origin: mustParseURL("http://localhost:8082"),
+		},
+	}
+
+	dispatcher, err := NewReloadableDispatcher(NewYAMLConfigLoader(configFile), services, WithoutFallback())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service := dispatcher.Dispatch("localhost", "/foo/"); service != services["foo"] {
+		t.Errorf("should dispatch to foo but got %v", service)
+	}
+	if service := dispatcher.Dispatch("localhost", "/bar/"); service != nil {
+		t.Errorf("should not dispatch but got %v", service)
+	}
+
+	t.Run("Reload", func(t *testing.T) {
+		writeConfig(heredoc.Doc(`
+			dispatch:
+			  - url: "*/bar/*"
+			    service: foo
+		`))
+
+		prev, next, err := dispatcher.Reload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prev.Len() != 1 || next.Len() != 1 {
+			t.Errorf("unexpected configs: prev=%v next=%v", prev, next)
+		}
+		if dispatcher.Config() != next {
+			t.Error("should use the reloaded config")
+		}
+		if service := dispatcher.Dispatch("localhost", "/foo/"); service != nil {
+			t.Errorf("should not dispatch but got %v", service)
+		}
+		if service := dispatcher.Dispatch("localhost", "/bar/"); service != services["foo"] {
+			t.Errorf("should dispatch to foo but got %v", service)
+		}
+	})
+
+	t.Run("KeepOnError", func(t *testing.T) {
+		current := dispatcher.Config()
+		for _, content := range []string{"dispatch: [", "dispatch:\n  - url: \"*/baz/*\"\n    service: baz\n"} {
+			writeConfig(content)
+
+			prev, next, err := dispatcher.Reload()
+			if err == nil {
+				t.Errorf("should be error for %q", content)
+			}
+			if prev != current || next != nil {
+				t.Errorf("unexpected configs: prev=%v next=%v", prev, next)
+			}
+			if dispatcher.Config() != current {
+				t.Error("should keep the current config")
+			}
+			if service := dispatcher.Dispatch("localhost", "/bar/"); service != services["foo"] {
+				t.Errorf("should dispatch to foo but got %v", service)
+			}
+		}
+	})
+
+	t.Run("Watch", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		reloaded := make(chan error, 1)
+		go dispatcher.Watch(ctx, configFile, 10*time.Millisecond, func(prev, next *Config, err error) {
+			select {
+			case reloaded <- err:
+			default:
+			}
+		})
+
+		// wait for the watcher to take the first stat
+		time.Sleep(50 * time.Millisecond)
+		writeConfig(heredoc.Doc(`
+			dispatch:
+			  - url: "*/watch/*"
+			    service: foo
+		`))
+
+		select {
+		case err := <-reloaded:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("should reload the modified config")
+		}
+		if service := dispatcher.Dispatch("localhost", "/watch/"); service != services["foo"] {
+			t.Errorf("should dispatch to foo but got %v", service)
+		}
+	})
+}
@@ -0,0 +1,160 @@
+package gaedispemu
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// RoutingCase is an expected routing of a URL
+type RoutingCase struct {
+	// Line is the line number of the case in the file
+	Line    int
+	URL     string
+	Service string
+}
+
+// RoutingCaseResult is the result of a RoutingCase
+type RoutingCaseResult struct {
+	Case   *RoutingCase
+	Result *DispatchResult
+	// Err is the error to parse the URL
+	Err error
+}
+
+// Passed returns true if the URL is routed to the expected service
+func (r *RoutingCaseResult) Passed() bool {
+	return r.Err == nil && r.Result.Service != nil && r.Result.Service.Name == r.Case.Service
+}
+
+func (r *RoutingCaseResult) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("%s: %v", r.Case.URL, r.Err)
+	}
+
+	service := "(none)"
+	if r.Result.Service != nil {
+		service = r.Result.Service.Name
+	}
+	if r.Passed() {
+		return fmt.Sprintf("%s -> %s %s", r.Case.URL, service, r.Result)
+	}
+	return fmt.Sprintf("%s: expected %s but got %s %s", r.Case.URL, r.Case.Service, service, r.Result)
+}
+
+// LoadRoutingCases loads the routing cases from the YAML (or JSON) file like:
+//
+//	tests:
+//	  - url: https://example.com/mobile/foo
+//	    service: mobile-frontend
+func LoadRoutingCases(filePath string) ([]*RoutingCase, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var document yaml.Node
+	if err := yaml.NewDecoder(f).Decode(&document); err != nil {
+		return nil, err
+	}
+	if len(document.Content) == 0 {
+		return nil, nil
+	}
+
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("yaml: line %d: routing cases should be a mapping", root.Line)
+	}
+
+	var cases []*RoutingCase
+	for i := 0; i < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Value != "tests" {
+			return nil, fmt.Errorf("yaml: line %d: unknown key: %s", key.Line, key.Value)
+		}
+		if value.Kind != yaml.SequenceNode {
+			return nil, fmt.Errorf("yaml: line %d: tests should be a sequence", value.Line)
+		}
+
+		for _, entry := range value.Content {
+			c, err := parseRoutingCase(entry)
+			if err != nil {
+				return nil, err
+			}
+			cases = append(cases, c)
+		}
+	}
+	return cases, nil
+}
+
+func parseRoutingCase(entry *yaml.Node) (*RoutingCase, error) {
+	if entry.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("yaml: line %d: routing case should be a mapping", entry.Line)
+	}
+
+	c := &RoutingCase{Line: entry.Line}
+	for i := 0; i < len(entry.Content); i += 2 {
+		key, value := entry.Content[i], entry.Content[i+1]
+
+		var field *string
+		switch key.Value {
+		case "url":
+			field = &c.URL
+		case "service":
+			field = &c.Service
+		default:
+			return nil, fmt.Errorf("yaml: line %d: unknown key: %s", key.Line, key.Value)
+		}
+
+		if err := value.Decode(field); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.URL == "" {
+		return nil, fmt.Errorf("yaml: line %d: url is required", c.Line)
+	}
+	if c.Service == "" {
+		return nil, fmt.Errorf("yaml: line %d: service is required", c.Line)
+	}
+	return c, nil
+}
+
+// RunRoutingCases dispatches the URLs of the cases and returns the results in order
+func RunRoutingCases(dispatcher Dispatcher, cases []*RoutingCase) []*RoutingCaseResult {
+	results := make([]*RoutingCaseResult, len(cases))
+	for i, c := range cases {
+		results[i] = &RoutingCaseResult{Case: c}
+
+		host, path, err := SplitURL(c.URL)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i].Result = Resolve(dispatcher, host, path)
+	}
+	return results
+}
+
+// SplitURL splits the URL into the host and the path for dispatching.
+// The scheme is optional (e.g. "example.com/mobile/foo").
+func SplitURL(rawURL string) (host, path string, err error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	path = u.Path
+	if path == "" {
+		path = "/"
+	}
+	return strings.ToLower(u.Hostname()), path, nil
+}
@@ -0,0 +1,77 @@
+package gaedispemu
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadRoutingCases(t *testing.T) {
+	cases, err := LoadRoutingCases("./testdata/dispatch_test.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*RoutingCase{
+		{Line: 2, URL: "https://example.com/favicon.ico", Service: "default"},
+		{Line: 4, URL: "simple-sample.appspot.com/mobile/foo", Service: "default"},
+		{Line: 6, URL: "https://example.com/mobile/foo", Service: "mobile-frontend"},
+		{Line: 8, URL: "http://localhost:8080/work/bar", Service: "static-backend"},
+		{Line: 10, URL: "https://example.com/mobile/foo", Service: "default"},
+	}
+	if diff := cmp.Diff(expected, cases); diff != "" {
+		t.Errorf("unexpected cases: %s", diff)
+	}
+
+	_, err = LoadRoutingCases("./testdata/dispatch.yaml")
+	if err == nil || err.Error() != "yaml: line 2: unknown key: dispatch" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRunRoutingCases(t *testing.T) {
+	config, err := NewYAMLConfigLoader("./testdata/dispatch.yaml").LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher, err := NewDispatcher(PlaceholderServices(config), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases, err := LoadRoutingCases("./testdata/dispatch_test.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases = append(cases, &RoutingCase{URL: "http://%zz/", Service: "default"})
+
+	results := RunRoutingCases(dispatcher, cases)
+	var passed []bool
+	var messages []string
+	for _, result := range results {
+		passed = append(passed, result.Passed())
+		messages = append(messages, result.String())
+	}
+
+	if diff := cmp.Diff([]bool{true, true, true, true, false, false}, passed); diff != "" {
+		t.Errorf("unexpected results: %s", diff)
+	}
+	expected := []string{
+		"https://example.com/favicon.ico -> default #0 */favicon.ico",
+		"simple-sample.appspot.com/mobile/foo -> default #1 simple-sample.appspot.com/",
+		"https://example.com/mobile/foo -> mobile-frontend #2 */mobile/*",
+		"http://localhost:8080/work/bar -> static-backend #3 */work/*",
+		"https://example.com/mobile/foo: expected default but got mobile-frontend #2 */mobile/*",
+	}
+	if diff := cmp.Diff(expected, messages[:5]); diff != "" {
+		t.Errorf("unexpected messages: %s", diff)
+	}
+
+	// the invalid URL is reported with the case
+	if results[5].Err == nil {
+		t.Error("should be failed for the invalid URL")
+	}
+	if !strings.HasPrefix(messages[5], "http://%zz/: ") {
+		t.Errorf("the message should mention the case: %s", messages[5])
+	}
+}
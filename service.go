@@ -1,9 +1,101 @@
 package gaedispemu
 
-import "net/url"
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
 
 // Service is a GAE service and backend origin
 type Service struct {
 	Name   string
 	Origin *url.URL
+
+	// Origins are the origins of the instances if the service has several instances, and Origin is the first of them
+	Origins []*url.URL
+	// Balancing is the way to choose the instance (BalancingRoundRobin if empty)
+	Balancing string
+	// Affinity makes the client stick to the instance by the cookie
+	Affinity bool
+
+	// Version is the version name if the service is a version of the service
+	Version string
+	// Versions are the versions of the service by the name
+	Versions map[string]*Service
+	// Split allocates the traffic to the versions (nil routes the traffic to the service itself)
+	Split *TrafficSplit
+
+	// Timeout is the timeout of the request to the backend (0 means no timeout)
+	Timeout time.Duration
+	// Headers are added to the request to the backend
+	Headers http.Header
+	// HTTP2 makes the proxy speak HTTP/2 to the backend (h2c for http:// origins)
+	HTTP2 bool
+	// TLS is the TLS settings for https:// origins (nil means the default settings)
+	TLS *ServiceTLS
+
+	// Dir is the directory of the service (e.g. found by DiscoverServices)
+	Dir string
+	// HealthCheck checks the backend, and the service is unavailable while it is unhealthy (nil means no checks)
+	HealthCheck *HealthCheck
+	// Command launches the backend of the service by Supervisor (e.g. "dev_appserver.py --port=$PORT .")
+	Command string
+}
+
+// Instances returns the origins of the instances of the service
+func (s *Service) Instances() []*url.URL {
+	if len(s.Origins) != 0 {
+		return s.Origins
+	}
+	if s.Origin == nil {
+		return nil
+	}
+	return []*url.URL{s.Origin}
+}
+
+// ParseOrigin parses the origin of the backend (e.g. "localhost:8081", "https://localhost:8443")
+func ParseOrigin(s string) (*url.URL, error) {
+	if strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") {
+		return url.Parse(s)
+	}
+
+	return url.Parse("http://" + s)
+}
+
+// ParseOrigins parses the comma separated origins of the instances (e.g. "localhost:8081,localhost:8082")
+func ParseOrigins(s string) ([]*url.URL, error) {
+	var origins []*url.URL
+	for _, part := range strings.Split(s, ",") {
+		origin, err := ParseOrigin(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		origins = append(origins, origin)
+	}
+	return origins, nil
+}
+
+// SetOrigins sets the origins of the instances to the service
+func (s *Service) SetOrigins(origins []*url.URL) {
+	s.Origin, s.Origins = nil, nil
+	if len(origins) != 0 {
+		s.Origin = origins[0]
+	}
+	if len(origins) > 1 {
+		s.Origins = origins
+	}
+}
+
+// PlaceholderServices creates the services without backends for all services in the configs
+func PlaceholderServices(configs ...*Config) map[string]*Service {
+	services := map[string]*Service{
+		DefaultServiceName: {Name: DefaultServiceName},
+	}
+	for _, config := range configs {
+		for _, rule := range config.Rules {
+			services[rule.ServiceName] = &Service{Name: rule.ServiceName}
+		}
+	}
+	return services
 }
@@ -0,0 +1,114 @@
+package gaedispemu
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// AppYAMLFileName is the file name to discover the services
+const AppYAMLFileName = "app.yaml"
+
+// portAnnotation is a comment in app.yaml to give the local port of the service
+// (e.g. "# gae-dispatcher-emulator: port=8082")
+var portAnnotation = regexp.MustCompile(`#\s*gae-dispatcher-emulator:\s*port=(\d+)`)
+
+type appYAML struct {
+	Service      string            `yaml:"service"`
+	Module       string            `yaml:"module"`
+	EnvVariables map[string]string `yaml:"env_variables"`
+}
+
+// DiscoverServices walks the directory tree and creates the services from app.yaml files.
+// The service name is read from `service:` (or `module:`), and it is the default service if omitted.
+// The port of the origin is read from the annotation comment "# gae-dispatcher-emulator: port=8082"
+// or PORT in env_variables, and the origin is nil if neither is given.
+// The hidden directories and node_modules are skipped.
+func DiscoverServices(root string) (map[string]*Service, error) {
+	services := map[string]*Service{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			name := info.Name()
+			if path != root && (strings.HasPrefix(name, ".") || name == "node_modules") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Name() != AppYAMLFileName {
+			return nil
+		}
+
+		service, err := loadAppYAML(path)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if found, ok := services[service.Name]; ok {
+			return fmt.Errorf("Duplicated service name: %s (%s and %s)", service.Name, filepath.Join(found.Dir, AppYAMLFileName), path)
+		}
+		services[service.Name] = service
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+func loadAppYAML(filePath string) (*Service, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var app appYAML
+	if err := yaml.Unmarshal(data, &app); err != nil {
+		return nil, err
+	}
+
+	service := &Service{Name: app.Service, Dir: filepath.Dir(filePath)}
+	if service.Name == "" {
+		service.Name = app.Module
+	}
+	if service.Name == "" {
+		service.Name = DefaultServiceName
+	}
+
+	port := app.EnvVariables["PORT"]
+	if m := portAnnotation.FindSubmatch(data); m != nil {
+		port = string(m[1])
+	}
+	if port != "" {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return nil, fmt.Errorf("Invalid port: %s", port)
+		}
+
+		origin, err := ParseOrigin("localhost:" + port)
+		if err != nil {
+			return nil, err
+		}
+		service.Origin = origin
+	}
+	return service, nil
+}
+
+// UndefinedServices returns the service names referenced by the rules but not defined in the services
+func UndefinedServices(config *Config, services map[string]*Service) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, rule := range config.Rules {
+		if _, ok := services[rule.ServiceName]; ok || seen[rule.ServiceName] {
+			continue
+		}
+		seen[rule.ServiceName] = true
+		names = append(names, rule.ServiceName)
+	}
+	return names
+}
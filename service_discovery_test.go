@@ -0,0 +1,86 @@
+package gaedispemu
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiscoverServices(t *testing.T) {
+	services, err := DiscoverServices("./testdata/apps")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]*Service{
+		"default":         {Name: "default", Dir: "testdata/apps/default"},
+		"mobile-frontend": {Name: "mobile-frontend", Origin: mustParseURL("http://localhost:8082"), Dir: "testdata/apps/mobile"},
+		"static-backend":  {Name: "static-backend", Origin: mustParseURL("http://localhost:8083"), Dir: "testdata/apps/static"},
+	}
+	if diff := cmp.Diff(expected, services); diff != "" {
+		t.Errorf("unexpected services: %s", diff)
+	}
+
+	config, err := NewYAMLConfigLoader("./testdata/dispatch.yaml").LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := UndefinedServices(config, services); len(names) != 0 {
+		t.Errorf("all services should be defined: %v", names)
+	}
+
+	delete(services, "mobile-frontend")
+	if diff := cmp.Diff([]string{"mobile-frontend"}, UndefinedServices(config, services)); diff != "" {
+		t.Errorf("unexpected undefined services: %s", diff)
+	}
+}
+
+func TestDiscoverServicesError(t *testing.T) {
+	writeAppYAML := func(t *testing.T, dir, content string) string {
+		t.Helper()
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		filePath := filepath.Join(dir, AppYAMLFileName)
+		if err := ioutil.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return filePath
+	}
+
+	t.Run("Duplicated", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "gaedispemu")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		writeAppYAML(t, filepath.Join(dir, "a"), "service: foo\n")
+		writeAppYAML(t, filepath.Join(dir, "b"), "service: foo\n")
+		if _, err := DiscoverServices(dir); err == nil {
+			t.Error("should be error")
+		}
+	})
+
+	t.Run("InvalidPort", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "gaedispemu")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		writeAppYAML(t, dir, "service: foo\nenv_variables:\n  PORT: \"99999\"\n")
+		if _, err := DiscoverServices(dir); err == nil {
+			t.Error("should be error")
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		if _, err := DiscoverServices("./testdata/naiyo-apps"); err == nil {
+			t.Error("should be error")
+		}
+	})
+}
@@ -0,0 +1,481 @@
+package gaedispemu
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// LoadServicesFile loads the services map from the YAML, JSON or TOML file like:
+//
+//	services:
+//	  default: localhost:8081
+//	  mobile-frontend:
+//	    origin: [localhost:8082, localhost:8083]
+//	    balancing: least-connections
+//	    affinity: true
+//	  admin:
+//	    origin: localhost:8082
+//	    timeout: 30s
+//	    http2: true
+//	    tls:
+//	      ca: ca.pem
+//	      cert: client.pem
+//	      key: client-key.pem
+//	      server_name: admin.internal
+//	    health_check:
+//	      path: /_ah/health
+//	      interval: 5s
+//	    command: go run . -port $PORT
+//	    headers:
+//	      X-Appengine-User-Is-Admin: "1"
+//	  api:
+//	    versions:
+//	      v1: localhost:8084
+//	      v2: localhost:8085
+//	    split:
+//	      shard_by: cookie
+//	      allocations: {v1: 0.9, v2: 0.1}
+//
+// The format is determined by the extension (.yaml, .yml, .json or .toml).
+func LoadServicesFile(filePath string) (map[string]*Service, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var root map[string]interface{}
+	switch filepath.Ext(filePath) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &root)
+	case ".json":
+		err = json.Unmarshal(data, &root)
+	case ".toml":
+		_, err = toml.Decode(string(data), &root)
+	default:
+		return nil, fmt.Errorf("Failed to determine services file type for %q", filePath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the relative paths in the file (e.g. tls.ca) are relative to the file
+	return parseServicesFile(root, filepath.Dir(filePath))
+}
+
+func parseServicesFile(root map[string]interface{}, baseDir string) (map[string]*Service, error) {
+	services := map[string]*Service{}
+	for key, value := range root {
+		if key != "services" {
+			return nil, fmt.Errorf("unknown key: %s", key)
+		}
+		if value == nil {
+			continue
+		}
+
+		entries, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("services should be a mapping")
+		}
+
+		for name, entry := range entries {
+			service, err := parseServiceEntry(name, entry, baseDir)
+			if err != nil {
+				return nil, fmt.Errorf("service %s: %v", name, err)
+			}
+			services[name] = service
+		}
+	}
+	return services, nil
+}
+
+func parseServiceEntry(name string, entry interface{}, baseDir string) (*Service, error) {
+	service := &Service{Name: name}
+
+	// the shorthand of the origins (e.g. default: localhost:8081)
+	fields, ok := entry.(map[string]interface{})
+	if !ok {
+		origins, err := parseOriginsField("service", entry)
+		if err != nil {
+			return nil, err
+		}
+		service.SetOrigins(origins)
+		return service, nil
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := fields[key]
+		switch key {
+		case "origin":
+			origins, err := parseOriginsField("origin", value)
+			if err != nil {
+				return nil, err
+			}
+			service.SetOrigins(origins)
+		case "balancing":
+			balancing, ok := value.(string)
+			if !ok || (balancing != BalancingRoundRobin && balancing != BalancingRandom && balancing != BalancingLeastConnections) {
+				return nil, fmt.Errorf("balancing should be %s, %s or %s", BalancingRoundRobin, BalancingRandom, BalancingLeastConnections)
+			}
+			service.Balancing = balancing
+		case "affinity":
+			affinity, ok := value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("affinity should be a boolean")
+			}
+			service.Affinity = affinity
+		case "command":
+			command, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("command should be a string")
+			}
+			service.Command = command
+		case "health_check":
+			check, err := parseHealthCheck(value)
+			if err != nil {
+				return nil, err
+			}
+			service.HealthCheck = check
+		case "timeout":
+			timeout, err := parseDurationField("timeout", value)
+			if err != nil {
+				return nil, err
+			}
+			if timeout <= 0 {
+				return nil, fmt.Errorf("timeout should be positive")
+			}
+			service.Timeout = timeout
+		case "headers":
+			headers, err := parseHeaders(value)
+			if err != nil {
+				return nil, err
+			}
+			service.Headers = headers
+		case "http2":
+			http2, ok := value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("http2 should be a boolean")
+			}
+			service.HTTP2 = http2
+		case "tls":
+			serviceTLS, err := parseServiceTLS(value, baseDir)
+			if err != nil {
+				return nil, err
+			}
+			service.TLS = serviceTLS
+		case "versions":
+			versions, err := parseVersions(name, value, baseDir)
+			if err != nil {
+				return nil, err
+			}
+			service.Versions = versions
+		case "split":
+			split, err := parseTrafficSplit(value)
+			if err != nil {
+				return nil, err
+			}
+			service.Split = split
+		default:
+			return nil, fmt.Errorf("unknown key: %s", key)
+		}
+	}
+
+	// the command is launched for the only origin
+	if service.Command != "" && len(service.Origins) > 1 {
+		return nil, fmt.Errorf("command is not supported for several origins")
+	}
+
+	// the versions are not checked, so the health check needs the origin of the service itself
+	if service.HealthCheck != nil && service.Versions != nil && service.Origin == nil && service.Command == "" {
+		return nil, fmt.Errorf("health_check needs origin because the versions are not health-checked")
+	}
+
+	if service.Split != nil {
+		for version := range service.Split.Allocations {
+			if _, ok := service.Versions[version]; !ok {
+				return nil, fmt.Errorf("split.allocations: undefined version: %s", version)
+			}
+		}
+	}
+	return service, nil
+}
+
+func parseVersions(name string, value interface{}, baseDir string) (map[string]*Service, error) {
+	entries, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("versions should be a mapping")
+	}
+
+	versions := make(map[string]*Service, len(entries))
+	for version, entry := range entries {
+		service, err := parseServiceEntry(name, entry, baseDir)
+		if err != nil {
+			return nil, fmt.Errorf("version %s: %v", version, err)
+		}
+		if service.Versions != nil || service.Split != nil {
+			return nil, fmt.Errorf("version %s: versions cannot be nested", version)
+		}
+		if service.Command != "" || service.HealthCheck != nil {
+			return nil, fmt.Errorf("version %s: command and health_check are not supported for versions", version)
+		}
+		if service.Origin == nil {
+			return nil, fmt.Errorf("version %s: origin is required", version)
+		}
+
+		service.Version = version
+		versions[version] = service
+	}
+	return versions, nil
+}
+
+func parseTrafficSplit(value interface{}) (*TrafficSplit, error) {
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("split should be a mapping")
+	}
+
+	split := &TrafficSplit{ShardBy: SplitByIP}
+	for key, value := range fields {
+		switch key {
+		case "shard_by":
+			shardBy, ok := value.(string)
+			if !ok || (shardBy != SplitByIP && shardBy != SplitByCookie && shardBy != SplitByRandom) {
+				return nil, fmt.Errorf("split.shard_by should be %s, %s or %s", SplitByIP, SplitByCookie, SplitByRandom)
+			}
+			split.ShardBy = shardBy
+		case "allocations":
+			allocations, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("split.allocations should be a mapping")
+			}
+
+			split.Allocations = make(map[string]float64, len(allocations))
+			for version, value := range allocations {
+				allocation, err := parseFloatField("split.allocations."+version, value)
+				if err != nil {
+					return nil, err
+				}
+				if allocation < 0 {
+					return nil, fmt.Errorf("split.allocations.%s should not be negative", version)
+				}
+				split.Allocations[version] = allocation
+			}
+		default:
+			return nil, fmt.Errorf("unknown key: split.%s", key)
+		}
+	}
+
+	total := 0.0
+	for _, allocation := range split.Allocations {
+		total += allocation
+	}
+	if math.Abs(total-1) > 1e-9 {
+		return nil, fmt.Errorf("split.allocations should sum to 1 but got %g", total)
+	}
+	return split, nil
+}
+
+// parseOriginsField parses the origin, the comma separated origins or the list of the origins
+func parseOriginsField(name string, value interface{}) ([]*url.URL, error) {
+	switch v := value.(type) {
+	case string:
+		return ParseOrigins(v)
+	case []interface{}:
+		origins := make([]*url.URL, len(v))
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s should be an origin or a list of origins", name)
+			}
+
+			origin, err := ParseOrigin(s)
+			if err != nil {
+				return nil, err
+			}
+			origins[i] = origin
+		}
+		return origins, nil
+	default:
+		return nil, fmt.Errorf("%s should be an origin or a list of origins", name)
+	}
+}
+
+func parseHeaders(value interface{}) (http.Header, error) {
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("headers should be a mapping")
+	}
+
+	headers := http.Header{}
+	for key, value := range fields {
+		switch v := value.(type) {
+		case string:
+			headers.Add(key, v)
+		case []interface{}:
+			for _, item := range v {
+				s, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("header %s should be a string or a list of strings", key)
+				}
+				headers.Add(key, s)
+			}
+		default:
+			return nil, fmt.Errorf("header %s should be a string or a list of strings", key)
+		}
+	}
+	return headers, nil
+}
+
+func parseHealthCheck(value interface{}) (*HealthCheck, error) {
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("health_check should be a mapping")
+	}
+
+	check := &HealthCheck{}
+	for key, value := range fields {
+		var err error
+		switch key {
+		case "path":
+			path, ok := value.(string)
+			if !ok || !strings.HasPrefix(path, "/") {
+				return nil, fmt.Errorf("health_check.path should be a path (e.g. /_ah/health)")
+			}
+			check.Path = path
+		case "interval":
+			check.Interval, err = parseDurationField("health_check.interval", value)
+			if err == nil && check.Interval <= 0 {
+				err = fmt.Errorf("health_check.interval should be positive")
+			}
+		case "timeout":
+			check.Timeout, err = parseDurationField("health_check.timeout", value)
+			if err == nil && check.Timeout <= 0 {
+				err = fmt.Errorf("health_check.timeout should be positive")
+			}
+		case "failure_threshold":
+			check.FailureThreshold, err = parseIntField("health_check.failure_threshold", value)
+			if err == nil && check.FailureThreshold <= 0 {
+				err = fmt.Errorf("health_check.failure_threshold should be positive")
+			}
+		case "success_threshold":
+			check.SuccessThreshold, err = parseIntField("health_check.success_threshold", value)
+			if err == nil && check.SuccessThreshold <= 0 {
+				err = fmt.Errorf("health_check.success_threshold should be positive")
+			}
+		default:
+			return nil, fmt.Errorf("unknown key: health_check.%s", key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if check.Path == "" {
+		return nil, fmt.Errorf("health_check.path is required")
+	}
+	return check, nil
+}
+
+func parseServiceTLS(value interface{}, baseDir string) (*ServiceTLS, error) {
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("tls should be a mapping")
+	}
+
+	serviceTLS := &ServiceTLS{}
+	for key, value := range fields {
+		if key == "insecure_skip_verify" {
+			insecure, ok := value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("tls.insecure_skip_verify should be a boolean")
+			}
+			serviceTLS.InsecureSkipVerify = insecure
+			continue
+		}
+
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("tls.%s should be a string", key)
+		}
+		switch key {
+		case "ca":
+			serviceTLS.CAFile = resolvePath(baseDir, s)
+		case "cert":
+			serviceTLS.CertFile = resolvePath(baseDir, s)
+		case "key":
+			serviceTLS.KeyFile = resolvePath(baseDir, s)
+		case "server_name":
+			serviceTLS.ServerName = s
+		default:
+			return nil, fmt.Errorf("unknown key: tls.%s", key)
+		}
+	}
+
+	// load the files to find the mistakes before requesting
+	if _, err := serviceTLS.ClientConfig(); err != nil {
+		return nil, fmt.Errorf("tls: %v", err)
+	}
+	return serviceTLS, nil
+}
+
+func resolvePath(baseDir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(baseDir, path)
+}
+
+func parseDurationField(name string, value interface{}) (time.Duration, error) {
+	s, ok := value.(string)
+	if !ok {
+		return 0, fmt.Errorf("%s should be a duration string (e.g. 30s)", name)
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s should be a duration string (e.g. 30s)", name)
+	}
+	return d, nil
+}
+
+// parseIntField accepts the number decoded from YAML (int), JSON (float64) or TOML (int64)
+func parseIntField(name string, value interface{}) (int, error) {
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), nil
+		}
+	}
+	return 0, fmt.Errorf("%s should be an integer", name)
+}
+
+// parseFloatField accepts the number decoded from YAML (int or float64), JSON (float64) or TOML (int64 or float64)
+func parseFloatField(name string, value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	}
+	return 0, fmt.Errorf("%s should be a number", name)
+}
@@ -0,0 +1,153 @@
+package gaedispemu
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadServicesFile(t *testing.T) {
+	expected := map[string]*Service{
+		"default": {Name: "default", Origin: mustParseURL("http://localhost:8081")},
+		"mobile-frontend": {
+			Name:      "mobile-frontend",
+			Origin:    mustParseURL("http://localhost:8082"),
+			Origins:   []*url.URL{mustParseURL("http://localhost:8082"), mustParseURL("http://localhost:8083")},
+			Balancing: BalancingLeastConnections,
+			Affinity:  true,
+		},
+		"admin": {
+			Name:    "admin",
+			Origin:  mustParseURL("https://localhost:8443"),
+			Timeout: 30 * time.Second,
+			HTTP2:   true,
+			TLS: &ServiceTLS{
+				CAFile:     filepath.Join("testdata", "tls", "ca.pem"),
+				CertFile:   filepath.Join("testdata", "tls", "client.pem"),
+				KeyFile:    filepath.Join("testdata", "tls", "client-key.pem"),
+				ServerName: "admin.internal",
+			},
+			HealthCheck: &HealthCheck{
+				Path:             "/_ah/health",
+				Interval:         time.Second,
+				FailureThreshold: 3,
+			},
+			Headers: http.Header{
+				"X-Appengine-User-Is-Admin": {"1"},
+				"X-Multi":                   {"a", "b"},
+			},
+		},
+		"api": {
+			Name: "api",
+			Versions: map[string]*Service{
+				"v1": {Name: "api", Version: "v1", Origin: mustParseURL("http://localhost:8084")},
+				"v2": {Name: "api", Version: "v2", Origin: mustParseURL("http://localhost:8085"), Timeout: 10 * time.Second},
+			},
+			Split: &TrafficSplit{
+				ShardBy:     SplitByCookie,
+				Allocations: map[string]float64{"v1": 0.75, "v2": 0.25},
+			},
+		},
+	}
+
+	for _, filePath := range []string{"./testdata/services.yaml", "./testdata/services.json", "./testdata/services.toml"} {
+		t.Run(filepath.Ext(filePath), func(t *testing.T) {
+			services, err := LoadServicesFile(filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(expected, services); diff != "" {
+				t.Errorf("unexpected services: %s", diff)
+			}
+		})
+	}
+}
+
+func TestLoadServicesFileError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gaedispemu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := map[string]string{
+		"services.txt":         "",
+		"unknown.yaml":         "service:\n  default: localhost:8081\n",
+		"unknown-field.yaml":   "services:\n  default:\n    port: 8081\n",
+		"timeout.yaml":         "services:\n  default:\n    timeout: 30\n",
+		"timeout-zero.yaml":    "services:\n  default:\n    timeout: 0s\n",
+		"timeout-neg.yaml":     "services:\n  default:\n    timeout: -1s\n",
+		"headers.yaml":         "services:\n  default:\n    headers: foo\n",
+		"broken.json":          "{",
+		"health-path.yaml":     "services:\n  default:\n    health_check:\n      interval: 1s\n",
+		"balancing.yaml":       "services:\n  default:\n    origin: localhost:8081\n    balancing: fastest\n",
+		"health-int.yaml":      "services:\n  default:\n    health_check:\n      path: /\n      success_threshold: 1.5\n",
+		"split-sum.yaml":       "services:\n  default:\n    versions:\n      v1: localhost:8081\n    split:\n      allocations:\n        v1: 0.5\n",
+		"split-version.yaml":   "services:\n  default:\n    versions:\n      v1: localhost:8081\n    split:\n      allocations:\n        v2: 1\n",
+		"split-shard.yaml":     "services:\n  default:\n    versions:\n      v1: localhost:8081\n    split:\n      shard_by: header\n      allocations:\n        v1: 1\n",
+		"split-health.yaml":    "services:\n  default:\n    versions:\n      v1: localhost:8081\n    split:\n      allocations:\n        v1: 1\n    health_check:\n      path: /\n",
+		"version-nest.yaml":    "services:\n  default:\n    versions:\n      v1:\n        versions:\n          v2: localhost:8081\n",
+		"http2.yaml":           "services:\n  default:\n    http2: yes please\n",
+		"tls-unknown.yaml":     "services:\n  default:\n    tls:\n      verify: false\n",
+		"tls-insecure.yaml":    "services:\n  default:\n    tls:\n      insecure_skip_verify: \"yes\"\n",
+		"tls-ca.yaml":          "services:\n  default:\n    tls:\n      ca: naiyo-ca.pem\n",
+		"tls-key.yaml":         "services:\n  default:\n    tls:\n      cert: client.pem\n",
+		"version-origin.yaml":  "services:\n  default:\n    versions:\n      v1:\n        timeout: 1s\n",
+		"command-origins.yaml": "services:\n  default:\n    origin: localhost:8081,localhost:8082\n    command: go run .\n",
+	}
+	for fileName, content := range cases {
+		t.Run(fileName, func(t *testing.T) {
+			filePath := filepath.Join(dir, fileName)
+			if err := ioutil.WriteFile(filePath, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := LoadServicesFile(filePath); err == nil {
+				t.Error("should be error")
+			}
+		})
+	}
+
+	if _, err := LoadServicesFile("./testdata/naiyo-services.yaml"); err == nil {
+		t.Error("should be error")
+	}
+}
+
+func TestLoadServicesFileHealthCheckError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gaedispemu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := map[string]string{
+		"interval: -1s":         "service default: health_check.interval should be positive",
+		"interval: 0s":          "service default: health_check.interval should be positive",
+		"interval: soon":        "service default: health_check.interval should be a duration string (e.g. 30s)",
+		"timeout: -4s":          "service default: health_check.timeout should be positive",
+		"failure_threshold: 0":  "service default: health_check.failure_threshold should be positive",
+		"success_threshold: -2": "service default: health_check.success_threshold should be positive",
+	}
+	for field, expected := range cases {
+		t.Run(field, func(t *testing.T) {
+			filePath := filepath.Join(dir, "services.yaml")
+			content := "services:\n  default:\n    health_check:\n      path: /\n      " + field + "\n"
+			if err := ioutil.WriteFile(filePath, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := LoadServicesFile(filePath)
+			if err == nil {
+				t.Error("should be error")
+			} else if s := err.Error(); s != expected {
+				t.Errorf("unexpected error: %s", s)
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package gaedispemu
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LocalVersionName is the version name given to the launched services as GAE_VERSION
+const LocalVersionName = "local"
+
+// Supervisor launches the commands of the services, and stops them.
+// It gives PORT, GAE_SERVICE and GAE_VERSION environment variables to the commands like App Engine runtime.
+type Supervisor struct {
+	services     []*Service
+	output       io.Writer
+	readyTimeout time.Duration
+	stopTimeout  time.Duration
+
+	outputMu  sync.Mutex
+	processes []*supervisedProcess
+}
+
+// SupervisorOption is an option for the supervisor
+type SupervisorOption func(*Supervisor)
+
+// WithOutput sets the writer for the output of the commands (os.Stdout is default)
+func WithOutput(w io.Writer) SupervisorOption {
+	return func(s *Supervisor) {
+		s.output = w
+	}
+}
+
+// WithReadyTimeout sets the timeout to wait until the services accept connections (30 seconds is default)
+func WithReadyTimeout(timeout time.Duration) SupervisorOption {
+	return func(s *Supervisor) {
+		s.readyTimeout = timeout
+	}
+}
+
+// WithStopTimeout sets the timeout to wait until the services exit before killing them (5 seconds is default)
+func WithStopTimeout(timeout time.Duration) SupervisorOption {
+	return func(s *Supervisor) {
+		s.stopTimeout = timeout
+	}
+}
+
+// NewSupervisor creates a supervisor for the services which have the command
+func NewSupervisor(services map[string]*Service, opts ...SupervisorOption) *Supervisor {
+	s := &Supervisor{
+		output:       os.Stdout,
+		readyTimeout: 30 * time.Second,
+		stopTimeout:  5 * time.Second,
+	}
+	for _, service := range services {
+		if service.Command != "" {
+			s.services = append(s.services, service)
+		}
+	}
+	sort.Slice(s.services, func(i, j int) bool {
+		return s.services[i].Name < s.services[j].Name
+	})
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Len returns the number of the supervised services
+func (s *Supervisor) Len() int {
+	return len(s.services)
+}
+
+type supervisedProcess struct {
+	service *Service
+	cmd     *exec.Cmd
+	// address is host:port to wait for ready
+	address string
+	done    chan struct{}
+	err     error
+}
+
+// Start launches the commands and waits until all of them accept connections.
+// The services without the origin get free ports on localhost as the origin,
+// and the services with several origins are not supported.
+// It stops the launched commands if any of them fails.
+func (s *Supervisor) Start(ctx context.Context) error {
+	for _, service := range s.services {
+		if len(service.Origins) > 1 {
+			return fmt.Errorf("Failed to launch the service %s: the command is not supported for several origins", service.Name)
+		}
+	}
+
+	for _, service := range s.services {
+		if service.Origin == nil {
+			port, err := getFreePort()
+			if err != nil {
+				s.Stop()
+				return fmt.Errorf("Failed to assign port for the service %s: %v", service.Name, err)
+			}
+
+			origin, err := ParseOrigin("localhost:" + strconv.Itoa(port))
+			if err != nil {
+				s.Stop()
+				return err
+			}
+			service.Origin = origin
+		}
+
+		process, err := s.launch(service)
+		if err != nil {
+			s.Stop()
+			return fmt.Errorf("Failed to launch the service %s: %v", service.Name, err)
+		}
+		s.processes = append(s.processes, process)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.readyTimeout)
+	defer cancel()
+
+	errs := make(chan error, len(s.processes))
+	for _, process := range s.processes {
+		go func(process *supervisedProcess) {
+			errs <- waitReady(ctx, process)
+		}(process)
+	}
+	for range s.processes {
+		if err := <-errs; err != nil {
+			s.Stop()
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Supervisor) launch(service *Service) (*supervisedProcess, error) {
+	port := service.Origin.Port()
+	if port == "" {
+		port = "80"
+		if service.Origin.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	cmd := shellCommand(service.Command)
+	cmd.Dir = service.Dir
+	cmd.Env = append(os.Environ(),
+		"PORT="+port,
+		"GAE_SERVICE="+service.Name,
+		"GAE_VERSION="+LocalVersionName,
+	)
+	output := &prefixWriter{w: s.output, mu: &s.outputMu, prefix: []byte(service.Name + " | ")}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	process := &supervisedProcess{
+		service: service,
+		cmd:     cmd,
+		address: net.JoinHostPort(service.Origin.Hostname(), port),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		process.err = cmd.Wait()
+		output.Flush()
+		close(process.done)
+	}()
+	return process, nil
+}
+
+// waitReady waits until the service accepts connections
+func waitReady(ctx context.Context, process *supervisedProcess) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if conn, err := net.DialTimeout("tcp", process.address, time.Second); err == nil {
+			conn.Close()
+			return nil
+		}
+
+		select {
+		case <-process.done:
+			return fmt.Errorf("The service %s exited before ready: %v", process.service.Name, process.err)
+		case <-ctx.Done():
+			return fmt.Errorf("The service %s is not ready on %s: %v", process.service.Name, process.address, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop terminates the commands, and kills them if they do not exit in time
+func (s *Supervisor) Stop() {
+	var wg sync.WaitGroup
+	for _, process := range s.processes {
+		wg.Add(1)
+		go func(process *supervisedProcess) {
+			defer wg.Done()
+
+			select {
+			case <-process.done:
+				return
+			default:
+			}
+
+			terminateProcess(process.cmd)
+			select {
+			case <-process.done:
+			case <-time.After(s.stopTimeout):
+				killProcess(process.cmd)
+				<-process.done
+			}
+		}(process)
+	}
+	wg.Wait()
+	s.processes = nil
+}
+
+func getFreePort() (int, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// prefixWriter writes each line with the prefix
+type prefixWriter struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	prefix []byte
+	buf    []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		index := bytes.IndexByte(w.buf, '\n')
+		if index == -1 {
+			break
+		}
+
+		line := append(append([]byte{}, w.prefix...), w.buf[:index+1]...)
+		w.buf = w.buf[index+1:]
+		if _, err := w.w.Write(line); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes the last line without newline
+func (w *prefixWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) != 0 {
+		w.w.Write(append(append(append([]byte{}, w.prefix...), w.buf...), '\n'))
+		w.buf = nil
+	}
+}
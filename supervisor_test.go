@@ -0,0 +1,146 @@
+package gaedispemu
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSupervisorHelperProcess is not a real test, but a backend launched by the supervisor in the tests
+func TestSupervisorHelperProcess(t *testing.T) {
+	if os.Getenv("GAEDISPEMU_HELPER_PROCESS") != "1" {
+		return
+	}
+
+	l, err := net.Listen("tcp", "localhost:"+os.Getenv("PORT"))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("listen on %s\n", os.Getenv("PORT"))
+	fmt.Print("no newline")
+	http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", os.Getenv("GAE_SERVICE"), os.Getenv("GAE_VERSION"))
+	}))
+}
+
+func helperCommand() string {
+	return fmt.Sprintf("GAEDISPEMU_HELPER_PROCESS=1 exec %s -test.run=TestSupervisorHelperProcess", os.Args[0])
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestSupervisor(t *testing.T) {
+	if testing.Short() {
+		t.Skip("launches processes")
+	}
+
+	services := map[string]*Service{
+		"default": {Name: "default", Command: helperCommand()},
+		"static":  {Name: "static", Origin: mustParseURL("http://203.0.113.1")},
+	}
+	var output syncBuffer
+	supervisor := NewSupervisor(services, WithOutput(&output), WithReadyTimeout(10*time.Second))
+	if supervisor.Len() != 1 {
+		t.Errorf("should supervise only the service with the command, but got %d", supervisor.Len())
+	}
+
+	if err := supervisor.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer supervisor.Stop()
+
+	origin := services["default"].Origin
+	if origin == nil {
+		t.Fatal("should assign the origin")
+	}
+
+	res, err := http.Get(origin.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "default "+LocalVersionName {
+		t.Errorf("unexpected response: %s", body)
+	}
+
+	supervisor.Stop()
+	if _, err := http.Get(origin.String()); err == nil {
+		t.Error("should stop the service")
+	}
+
+	expected := fmt.Sprintf("default | listen on %s\ndefault | no newline\n", origin.Port())
+	if out := output.String(); !strings.HasPrefix(out, expected) {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
+
+func TestSupervisorError(t *testing.T) {
+	if testing.Short() {
+		t.Skip("launches processes")
+	}
+
+	t.Run("Origins", func(t *testing.T) {
+		origins, err := ParseOrigins("localhost:8081,localhost:8082")
+		if err != nil {
+			t.Fatal(err)
+		}
+		service := &Service{Name: "default", Command: "exit 0"}
+		service.SetOrigins(origins)
+
+		supervisor := NewSupervisor(map[string]*Service{"default": service}, WithOutput(ioutil.Discard))
+		err = supervisor.Start(context.Background())
+		if err == nil || !strings.Contains(err.Error(), "not supported for several origins") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Exited", func(t *testing.T) {
+		services := map[string]*Service{
+			"default": {Name: "default", Command: "exit 1"},
+		}
+		supervisor := NewSupervisor(services, WithOutput(ioutil.Discard))
+		err := supervisor.Start(context.Background())
+		if err == nil || !strings.Contains(err.Error(), "exited before ready") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		services := map[string]*Service{
+			"default": {Name: "default", Command: "sleep 10"},
+		}
+		supervisor := NewSupervisor(services, WithOutput(ioutil.Discard), WithReadyTimeout(200*time.Millisecond), WithStopTimeout(time.Second))
+		err := supervisor.Start(context.Background())
+		if err == nil || !strings.Contains(err.Error(), "is not ready") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package gaedispemu
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// shellCommand runs the command by sh in the new process group to stop the children together
+func shellCommand(command string) *exec.Cmd {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd
+}
+
+func terminateProcess(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+}
+
+func killProcess(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
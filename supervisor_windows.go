@@ -0,0 +1,19 @@
+//go:build windows
+// +build windows
+
+package gaedispemu
+
+import "os/exec"
+
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("cmd", "/C", command)
+}
+
+func terminateProcess(cmd *exec.Cmd) {
+	// Windows has no signals to terminate gracefully
+	cmd.Process.Kill()
+}
+
+func killProcess(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,80 @@
+package gaedispemu
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+const (
+	// SplitByIP splits the traffic by the hash of the client IP address
+	SplitByIP = "ip"
+	// SplitByCookie splits the traffic by GOOGAPPUID cookie, and gives the cookie to the new clients
+	SplitByCookie = "cookie"
+	// SplitByRandom splits the traffic at random for each request
+	SplitByRandom = "random"
+)
+
+// SplitCookieName is the cookie name to split the traffic by cookie as same as App Engine
+const SplitCookieName = "GOOGAPPUID"
+
+// splitBuckets is the number of the buckets to split the traffic (GOOGAPPUID is in 0-999)
+const splitBuckets = 1000
+
+// TrafficSplit is the allocations of the traffic to the versions of the service
+type TrafficSplit struct {
+	// ShardBy is the way to split the traffic (SplitByIP if empty)
+	ShardBy string
+	// Allocations are the ratio of the traffic by the version name (the sum should be 1)
+	Allocations map[string]float64
+}
+
+// chooseVersion returns the version of the service for the request by the traffic split (nil if the service has no split)
+func (s *Service) chooseVersion(w http.ResponseWriter, r *http.Request) *Service {
+	if s.Split == nil || len(s.Split.Allocations) == 0 {
+		return nil
+	}
+
+	var bucket int
+	switch s.Split.ShardBy {
+	case SplitByCookie:
+		bucket = -1
+		if cookie, err := r.Cookie(SplitCookieName); err == nil {
+			if n, err := strconv.Atoi(cookie.Value); err == nil && 0 <= n && n < splitBuckets {
+				bucket = n
+			}
+		}
+		if bucket == -1 {
+			bucket = rand.Intn(splitBuckets)
+			http.SetCookie(w, &http.Cookie{Name: SplitCookieName, Value: strconv.Itoa(bucket), Path: "/"})
+		}
+	case SplitByRandom:
+		bucket = rand.Intn(splitBuckets)
+	default:
+		h := fnv.New32a()
+		h.Write([]byte(getRemoteIP(r)))
+		bucket = int(h.Sum32() % splitBuckets)
+	}
+
+	return s.Versions[s.Split.versionForBucket(bucket)]
+}
+
+// versionForBucket allocates the buckets to the versions in the order of the name
+func (s *TrafficSplit) versionForBucket(bucket int) string {
+	names := make([]string, 0, len(s.Allocations))
+	for name := range s.Allocations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	total := 0.0
+	for _, name := range names {
+		total += s.Allocations[name]
+		if float64(bucket) < total*splitBuckets {
+			return name
+		}
+	}
+	return names[len(names)-1]
+}
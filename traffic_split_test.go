@@ -0,0 +1,158 @@
+package gaedispemu
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func newTestSplitService(shardBy string) *Service {
+	return &Service{
+		Name: "default",
+		Versions: map[string]*Service{
+			"v1": {Name: "default", Version: "v1", Origin: mustParseURL("http://localhost:8081")},
+			"v2": {Name: "default", Version: "v2", Origin: mustParseURL("http://localhost:8082")},
+		},
+		Split: &TrafficSplit{
+			ShardBy:     shardBy,
+			Allocations: map[string]float64{"v1": 0.75, "v2": 0.25},
+		},
+	}
+}
+
+func TestTrafficSplitVersionForBucket(t *testing.T) {
+	split := newTestSplitService(SplitByIP).Split
+	cases := map[int]string{0: "v1", 749: "v1", 750: "v2", 999: "v2"}
+	for bucket, expected := range cases {
+		if version := split.versionForBucket(bucket); version != expected {
+			t.Errorf("bucket %d should be allocated to %s, but got %s", bucket, expected, version)
+		}
+	}
+}
+
+func TestServiceChooseVersion(t *testing.T) {
+	t.Run("NoSplit", func(t *testing.T) {
+		service := &Service{Name: "default"}
+		if version := service.chooseVersion(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)); version != nil {
+			t.Errorf("should not choose any version, but got %s", version.Version)
+		}
+	})
+
+	t.Run("Cookie", func(t *testing.T) {
+		service := newTestSplitService(SplitByCookie)
+
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: SplitCookieName, Value: "800"})
+		if version := service.chooseVersion(recorder, req); version.Version != "v2" {
+			t.Errorf("should choose v2, but got %s", version.Version)
+		}
+		if cookies := recorder.Result().Cookies(); len(cookies) != 0 {
+			t.Errorf("should not set the cookie again: %v", cookies)
+		}
+
+		recorder = httptest.NewRecorder()
+		version := service.chooseVersion(recorder, httptest.NewRequest("GET", "/", nil))
+		cookies := recorder.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != SplitCookieName {
+			t.Fatalf("unexpected cookies: %v", cookies)
+		}
+		bucket, err := strconv.Atoi(cookies[0].Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := service.Split.versionForBucket(bucket); version.Version != expected {
+			t.Errorf("should choose %s by the given cookie, but got %s", expected, version.Version)
+		}
+	})
+
+	t.Run("IP", func(t *testing.T) {
+		service := newTestSplitService(SplitByIP)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		expected := service.chooseVersion(httptest.NewRecorder(), req)
+		for i := 0; i < 10; i++ {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = "192.0.2.1:" + strconv.Itoa(40000+i)
+			if version := service.chooseVersion(httptest.NewRecorder(), req); version != expected {
+				t.Errorf("should stick to %s, but got %s", expected.Version, version.Version)
+			}
+		}
+	})
+
+	t.Run("Random", func(t *testing.T) {
+		service := newTestSplitService(SplitByRandom)
+		if version := service.chooseVersion(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)); version == nil {
+			t.Error("should choose a version")
+		}
+	})
+}
+
+func TestProxyHandlerTrafficSplit(t *testing.T) {
+	service := newTestSplitService(SplitByCookie)
+	for _, name := range []string{"v1", "v2"} {
+		backend := httptest.NewServer(getBackendHandler(name))
+		defer backend.Close()
+		service.Versions[name].Origin = mustParseURL(backend.URL)
+	}
+
+	dispatcher, err := NewDispatcher(map[string]*Service{"default": service}, &Config{}, WithProject("myproject", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewProxyHandler(dispatcher)
+
+	cases := []struct {
+		Host    string
+		Version string
+	}{
+		{Host: "example.com", Version: "v1"},
+		{Host: "v2-dot-myproject.appspot.com", Version: "v2"},
+	}
+	for _, c := range cases {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = c.Host
+		req.Header.Set("Status", "200")
+		req.AddCookie(&http.Cookie{Name: SplitCookieName, Value: "100"})
+		handler.ServeHTTP(recorder, req)
+
+		res := recorder.Result()
+		if s := res.Header.Get("Service"); s != c.Version {
+			t.Errorf("%s should be proxied to %s, but got %s", c.Host, c.Version, s)
+		}
+		if v := res.Header.Get(DispatchVersionHeader); v != c.Version {
+			t.Errorf("%s has unexpected %s: %s", c.Host, DispatchVersionHeader, v)
+		}
+	}
+}
+
+func TestProxyHandlerTrafficSplitHealthCheck(t *testing.T) {
+	backend := httptest.NewServer(getBackendHandler("v1"))
+	defer backend.Close()
+
+	// the service itself is never healthy, but the versions are not gated by it
+	service := newTestSplitService(SplitByCookie)
+	service.Origin = mustParseURL("http://203.0.113.1")
+	service.HealthCheck = &HealthCheck{Path: "/"}
+	service.Versions["v1"].Origin = mustParseURL(backend.URL)
+	services := map[string]*Service{"default": service}
+	dispatcher, err := NewDispatcher(services, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewProxyHandler(dispatcher, WithHealthChecker(NewHealthChecker(services)))
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: SplitCookieName, Value: "100"})
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("unexpected status: %d", recorder.Code)
+	}
+	if s := recorder.Result().Header.Get("Service"); s != "v1" {
+		t.Errorf("should be proxied to v1, but got %s", s)
+	}
+}
@@ -0,0 +1,117 @@
+package gaedispemu
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ServiceTLS is the TLS settings to request to the https:// origins of the service
+type ServiceTLS struct {
+	// CAFile is the PEM file of the CA certificates to verify the backend (the system roots if empty)
+	CAFile string
+	// CertFile and KeyFile are the client certificate and the private key for mutual TLS
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the hostname to verify the certificate of the backend and to send by SNI
+	ServerName string
+	// InsecureSkipVerify skips verifying the certificate of the backend (e.g. self-signed certificates)
+	InsecureSkipVerify bool
+}
+
+// ClientConfig loads the files and returns the config for the client
+func (t *ServiceTLS) ClientConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", t.CAFile)
+		}
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		if t.CertFile == "" || t.KeyFile == "" {
+			return nil, fmt.Errorf("both of the client certificate and the private key are required")
+		}
+
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// TransportWrapper wraps the transport to the backends of the service (e.g. to log the requests)
+type TransportWrapper func(transport http.RoundTripper) http.RoundTripper
+
+// newServiceTransport creates the transport to the backends of the service
+func newServiceTransport(service *Service) (http.RoundTripper, error) {
+	if !service.HTTP2 && service.TLS == nil {
+		return http.DefaultTransport, nil
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	if service.HTTP2 {
+		// HTTP/2 is negotiated by ALPN for https:// origins,
+		// and cleartext HTTP/2 with prior knowledge (h2c) is used for http:// origins.
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	if service.TLS != nil {
+		config, err := service.TLS.ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to configure TLS for the service %s: %v", service.Name, err)
+		}
+		transport.TLSClientConfig = config
+	}
+
+	if service.HTTP2 {
+		http1 := transport.Clone()
+		http1.Protocols = new(http.Protocols)
+		http1.Protocols.SetHTTP1(true)
+		return &upgradeFallbackTransport{RoundTripper: transport, http1: http1}, nil
+	}
+	return transport, nil
+}
+
+// upgradeFallbackTransport requests by HTTP/1.1 for the upgrade requests (e.g. WebSocket)
+// because HTTP/2 has no way to upgrade the connection.
+type upgradeFallbackTransport struct {
+	http.RoundTripper
+	http1 http.RoundTripper
+}
+
+func (t *upgradeFallbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if isUpgradeRequest(req) {
+		return t.http1.RoundTrip(req)
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
@@ -0,0 +1,246 @@
+package gaedispemu
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func newH2CProtocols() *http.Protocols {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	return protocols
+}
+
+func TestProxyHandlerHTTP2(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	}))
+	backend.Config.Protocols = newH2CProtocols()
+	backend.Start()
+	defer backend.Close()
+
+	for _, http2 := range []bool{false, true} {
+		service := &Service{Name: "default", Origin: mustParseURL(backend.URL), HTTP2: http2}
+		dispatcher, err := NewDispatcher(map[string]*Service{"default": service}, &Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		proxy := httptest.NewUnstartedServer(NewProxyHandler(dispatcher))
+		proxy.Config.Protocols = newH2CProtocols()
+		proxy.Start()
+		defer proxy.Close()
+
+		// the client speaks h2c to the proxy
+		protocols := new(http.Protocols)
+		protocols.SetUnencryptedHTTP2(true)
+		client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+		res, err := client.Get(proxy.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.Proto != "HTTP/2.0" {
+			t.Errorf("the proxy should respond by HTTP/2, but got %s", res.Proto)
+		}
+		expected := "HTTP/1.1"
+		if http2 {
+			expected = "HTTP/2.0"
+		}
+		if string(body) != expected {
+			t.Errorf("the backend should be requested by %s, but got %s (http2: %v)", expected, body, http2)
+		}
+	}
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestProxyHandlerTransportWrapper(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	}))
+	backend.Config.Protocols = newH2CProtocols()
+	backend.Start()
+	defer backend.Close()
+
+	services := map[string]*Service{
+		"default": {Name: "default", Origin: mustParseURL(backend.URL)},
+		"h2":      {Name: "h2", Origin: mustParseURL(backend.URL), HTTP2: true},
+	}
+	config := &Config{Rules: []ConfigRule{{ServiceName: "h2", HostPathMatcher: mustCompileHostPathMatcher("*/h2/*")}}}
+	dispatcher, err := NewDispatcher(services, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the transports of all services are wrapped
+	var wrapped []string
+	handler := NewProxyHandler(dispatcher, WithTransportWrapper(func(transport http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			wrapped = append(wrapped, req.URL.Path)
+			return transport.RoundTrip(req)
+		})
+	}))
+	for _, path := range []string{"/", "/h2/", "/"} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		if recorder.Code != http.StatusOK {
+			t.Errorf("unexpected status: %d", recorder.Code)
+		}
+		if path == "/h2/" && recorder.Body.String() != "HTTP/2.0" {
+			t.Errorf("the backend should be requested by HTTP/2, but got %s", recorder.Body.String())
+		}
+	}
+	if diff := cmp.Diff([]string{"/", "/h2/", "/"}, wrapped); diff != "" {
+		t.Errorf("unexpected wrapped requests: %s", diff)
+	}
+}
+
+func TestProxyHandlerHTTP2Upgrade(t *testing.T) {
+	backend := newEchoUpgradeBackend()
+	defer backend.Close()
+
+	service := &Service{Name: "default", Origin: mustParseURL(backend.URL), HTTP2: true}
+	dispatcher, err := NewDispatcher(map[string]*Service{"default": service}, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := httptest.NewServer(NewProxyHandler(dispatcher))
+	defer proxy.Close()
+
+	// the upgrade request is forwarded by HTTP/1.1
+	conn, reader := dialUpgrade(t, proxy)
+	defer conn.Close()
+
+	io.WriteString(conn, "hello\n")
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "hello\n" {
+		t.Errorf("Unexpected echo: %q", line)
+	}
+}
+
+func TestNewServiceTransport(t *testing.T) {
+	if transport, err := newServiceTransport(&Service{Name: "default"}); err != nil {
+		t.Fatal(err)
+	} else if transport != http.DefaultTransport {
+		t.Errorf("should be http.DefaultTransport but got %v", transport)
+	}
+	if transport, err := newServiceTransport(&Service{Name: "default", HTTP2: true}); err != nil {
+		t.Fatal(err)
+	} else if transport == http.DefaultTransport {
+		t.Error("should not be http.DefaultTransport")
+	}
+	if _, err := newServiceTransport(&Service{Name: "default", TLS: &ServiceTLS{CAFile: "./testdata/naiyo-ca.pem"}}); err == nil {
+		t.Error("should be error")
+	}
+}
+
+func TestProxyHandlerServiceTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gaedispemu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, err := NewCertificateAuthority()
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caFile, ca.CertificatePEM(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the backend requires the client certificate issued by the testing CA
+	clientCAs := x509.NewCertPool()
+	clientCA, err := ioutil.ReadFile("./testdata/tls/ca.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs.AppendCertsFromPEM(clientCA)
+
+	cert, err := ca.Certificate("backend.internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	backend.TLS = &tls.Config{Certificates: []tls.Certificate{*cert}, ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	backend.Config.ErrorLog = discardLogger
+	backend.StartTLS()
+	defer backend.Close()
+
+	cases := map[string]struct {
+		TLS    *ServiceTLS
+		Status int
+	}{
+		"Default": {
+			TLS:    nil,
+			Status: http.StatusBadGateway,
+		},
+		"CA": {
+			TLS:    &ServiceTLS{CAFile: caFile, ServerName: "backend.internal", CertFile: "./testdata/tls/client.pem", KeyFile: "./testdata/tls/client-key.pem"},
+			Status: http.StatusOK,
+		},
+		"Insecure": {
+			TLS:    &ServiceTLS{InsecureSkipVerify: true, CertFile: "./testdata/tls/client.pem", KeyFile: "./testdata/tls/client-key.pem"},
+			Status: http.StatusOK,
+		},
+		"ServerNameMismatch": {
+			TLS:    &ServiceTLS{CAFile: caFile, CertFile: "./testdata/tls/client.pem", KeyFile: "./testdata/tls/client-key.pem"},
+			Status: http.StatusBadGateway,
+		},
+		"NoClientCertificate": {
+			TLS:    &ServiceTLS{CAFile: caFile, ServerName: "backend.internal"},
+			Status: http.StatusBadGateway,
+		},
+		"BrokenCA": {
+			TLS:    &ServiceTLS{CAFile: "./testdata/tls/client-key.pem"},
+			Status: http.StatusBadGateway,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			service := &Service{Name: "default", Origin: mustParseURL(backend.URL), TLS: c.TLS}
+			dispatcher, err := NewDispatcher(map[string]*Service{"default": service}, &Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			NewProxyHandler(dispatcher).ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+			if status := recorder.Result().StatusCode; status != c.Status {
+				t.Errorf("Unexpected response status: %d (%s)", status, recorder.Body.String())
+			}
+			if c.Status == http.StatusOK {
+				if body := recorder.Body.String(); body != "gae-dispatcher-emulator testing client" {
+					t.Errorf("Unexpected client certificate: %q", body)
+				}
+			}
+		})
+	}
+}
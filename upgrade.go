@@ -0,0 +1,99 @@
+package gaedispemu
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// UpgradedConnection is a connection upgraded from HTTP by the backend (e.g. WebSocket)
+type UpgradedConnection struct {
+	// Service is the name of the service
+	Service string
+	// Version is the version of the service (empty if the service has no versions)
+	Version string
+	// Origin is the instance of the service which accepted the upgrade
+	Origin *url.URL
+	// Protocol is the value of Upgrade header (e.g. websocket)
+	Protocol   string
+	RemoteAddr string
+	Path       string
+	Opened     time.Time
+	// Closed is the time when the connection is closed (zero while it is open)
+	Closed time.Time
+	// Sent is the bytes sent from the client to the backend
+	Sent int64
+	// Received is the bytes received by the client from the backend
+	Received int64
+}
+
+// UpgradeHandler is called when the connection is upgraded, and called again when it is closed
+type UpgradeHandler func(conn *UpgradedConnection)
+
+// WithUpgradeHandler sets the handler to watch the upgraded connections (e.g. for logging)
+func WithUpgradeHandler(handler UpgradeHandler) ProxyHandlerOption {
+	return func(h *proxyHandler) {
+		h.upgradeHandler = handler
+	}
+}
+
+// WithUpgradeContext closes the upgraded connections when the context is done.
+// http.Server.Shutdown does not wait for nor close the upgraded connections, so give the context canceled on shutdown.
+func WithUpgradeContext(ctx context.Context) ProxyHandlerOption {
+	return func(h *proxyHandler) {
+		h.upgradeContext = ctx
+	}
+}
+
+// isUpgradeRequest returns true if the request asks to upgrade the protocol (e.g. WebSocket handshake)
+func isUpgradeRequest(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+
+	for _, value := range r.Header["Connection"] {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withUpgradeContext cancels the request context of the upgrade request when ctx is done
+// because httputil.ReverseProxy closes the upgraded connections by the request context.
+func withUpgradeContext(r *http.Request, ctx context.Context) (*http.Request, context.CancelFunc) {
+	reqCtx, cancel := context.WithCancel(r.Context())
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-reqCtx.Done():
+		}
+	}()
+	return r.WithContext(reqCtx), cancel
+}
+
+// countingConn counts the bytes spliced through the upgraded connection to the backend
+type countingConn struct {
+	io.ReadWriteCloser
+	read    int64
+	written int64
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	atomic.AddInt64(&c.read, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	atomic.AddInt64(&c.written, int64(n))
+	return n, err
+}
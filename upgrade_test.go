@@ -0,0 +1,145 @@
+package gaedispemu
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newEchoUpgradeBackend upgrades the connection to "echo" protocol which echoes back the received bytes
+func newEchoUpgradeBackend() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			http.Error(w, "Upgrade Required", http.StatusUpgradeRequired)
+			return
+		}
+
+		conn, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		io.Copy(conn, buf)
+	}))
+}
+
+// dialUpgrade sends the upgrade request to the server and returns the upgraded connection
+func dialUpgrade(t *testing.T, server *httptest.Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(conn, "GET /echo HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Unexpected response status: %d", res.StatusCode)
+	}
+	if v := res.Header.Get(DispatchRuleHeader); v != "(fallback)" {
+		t.Errorf("Unexpected %s: %q", DispatchRuleHeader, v)
+	}
+	return conn, reader
+}
+
+func TestProxyHandlerUpgrade(t *testing.T) {
+	backend := newEchoUpgradeBackend()
+	defer backend.Close()
+
+	service := &Service{Name: "default", Origin: mustParseURL(backend.URL), Timeout: 10 * time.Millisecond}
+	dispatcher, err := NewDispatcher(map[string]*Service{"default": service}, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan UpgradedConnection, 2)
+	proxy := httptest.NewServer(NewProxyHandler(dispatcher, WithUpgradeHandler(func(conn *UpgradedConnection) {
+		events <- *conn
+	})))
+	defer proxy.Close()
+
+	conn, reader := dialUpgrade(t, proxy)
+	opened := <-events
+	if opened.Service != "default" || opened.Protocol != "echo" || opened.Path != "/echo" || opened.Origin != service.Origin || !opened.Closed.IsZero() {
+		t.Errorf("Unexpected opened connection: %+v", opened)
+	}
+
+	// the timeout of the service is not applied to the upgraded connection
+	time.Sleep(50 * time.Millisecond)
+
+	io.WriteString(conn, "hello\n")
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "hello\n" {
+		t.Errorf("Unexpected echo: %q", line)
+	}
+	conn.Close()
+
+	select {
+	case closed := <-events:
+		if closed.Closed.IsZero() || closed.Sent != 6 || closed.Received != 6 {
+			t.Errorf("Unexpected closed connection: %+v", closed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("should close the upgraded connection")
+	}
+}
+
+func TestProxyHandlerUpgradeContext(t *testing.T) {
+	backend := newEchoUpgradeBackend()
+	defer backend.Close()
+
+	dispatcher, err := NewDispatcher(map[string]*Service{"default": {Name: "default", Origin: mustParseURL(backend.URL)}}, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	proxy := httptest.NewServer(NewProxyHandler(dispatcher, WithUpgradeContext(ctx)))
+	defer proxy.Close()
+
+	conn, reader := dialUpgrade(t, proxy)
+	defer conn.Close()
+
+	cancel()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("should be closed by the context but got %v", err)
+	}
+}
+
+func TestIsUpgradeRequest(t *testing.T) {
+	cases := []struct {
+		Connection, Upgrade string
+		Expected            bool
+	}{
+		{Connection: "Upgrade", Upgrade: "websocket", Expected: true},
+		{Connection: "keep-alive, upgrade", Upgrade: "websocket", Expected: true},
+		{Connection: "keep-alive", Upgrade: "websocket", Expected: false},
+		{Connection: "Upgrade", Upgrade: "", Expected: false},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Connection", c.Connection)
+		req.Header.Set("Upgrade", c.Upgrade)
+		if got := isUpgradeRequest(req); got != c.Expected {
+			t.Errorf("should be %v for Connection: %q, Upgrade: %q", c.Expected, c.Connection, c.Upgrade)
+		}
+	}
+}
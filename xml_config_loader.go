@@ -1,17 +1,25 @@
 package gaedispemu
 
 import (
+	"bytes"
 	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"strings"
 )
 
-type dispatchXML struct {
-	Entries []dispatchEntryXML `xml:"dispatch"`
+var errNoRootElement = errors.New("xml: no root element")
+
+// xmlDecoder is a xml.Decoder that knows the line number of the current position
+type xmlDecoder struct {
+	*xml.Decoder
+	data []byte
 }
 
-type dispatchEntryXML struct {
-	URL    string `xml:"url"`
-	Module string `xml:"module"`
+func (d *xmlDecoder) line() int {
+	return bytes.Count(d.data[:d.InputOffset()], []byte("\n")) + 1
 }
 
 // XMLConfigLoader is a config loader for dispatch.xml
@@ -19,6 +27,11 @@ type XMLConfigLoader struct {
 	filePath string
 }
 
+var (
+	_ ConfigLoader    = (*XMLConfigLoader)(nil)
+	_ ConfigValidator = (*XMLConfigLoader)(nil)
+)
+
 // NewXMLConfigLoader is constructor of XMLConfigLoader
 func NewXMLConfigLoader(filePath string) *XMLConfigLoader {
 	return &XMLConfigLoader{filePath: filePath}
@@ -26,35 +39,141 @@ func NewXMLConfigLoader(filePath string) *XMLConfigLoader {
 
 // LoadConfig loads and parse the dispatch.xml
 func (l *XMLConfigLoader) LoadConfig() (*Config, error) {
-	f, err := os.Open(l.filePath)
+	raw, err := l.loadRawConfig()
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
 
-	decoder := xml.NewDecoder(f)
+	return raw.compile()
+}
 
-	var v dispatchXML
-	err = decoder.Decode(&v)
+// ValidateConfig loads and validate the dispatch.xml
+func (l *XMLConfigLoader) ValidateConfig() error {
+	raw, err := l.loadRawConfig()
+	if err != nil {
+		return err
+	}
+
+	return raw.validate()
+}
+
+func (l *XMLConfigLoader) loadRawConfig() (*rawConfig, error) {
+	data, err := os.ReadFile(l.filePath)
 	if err != nil {
 		return nil, err
 	}
 
-	return l.transform(&v)
+	decoder := &xmlDecoder{Decoder: xml.NewDecoder(bytes.NewReader(data)), data: data}
+	var comments []string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, errNoRootElement
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.Comment:
+			comments = append(comments, uncommentXML(t))
+		case xml.StartElement:
+			// <dispatch-entries>
+			config, err := l.parseEntries(decoder)
+			if err != nil {
+				return nil, err
+			}
+
+			config.Comment = joinComments(comments...)
+			return config, nil
+		}
+	}
 }
 
-func (l *XMLConfigLoader) transform(rawConfifg *dispatchXML) (*Config, error) {
-	rules := make([]ConfigRule, len(rawConfifg.Entries))
-	for i, entry := range rawConfifg.Entries {
-		hostPathMatcher, err := CompileHostPathMatcher(entry.URL)
+func (l *XMLConfigLoader) parseEntries(decoder *xmlDecoder) (*rawConfig, error) {
+	config := &rawConfig{}
+	var comments []string
+	for {
+		token, err := decoder.Token()
 		if err != nil {
 			return nil, err
 		}
+		line := decoder.line()
 
-		rules[i] = ConfigRule{
-			ServiceName:     entry.Module,
-			HostPathMatcher: hostPathMatcher,
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "dispatch" {
+				config.Problems = append(config.Problems, &ValidationError{Line: line, Message: fmt.Sprintf("unknown element: <%s>", t.Name.Local)})
+				if err := decoder.Skip(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			rule, problems, err := l.parseEntry(decoder, line)
+			if err != nil {
+				return nil, err
+			}
+
+			// the comments before <dispatch> are also for the rule
+			rule.Comment = joinComments(append(comments, rule.Comment)...)
+			comments = nil
+
+			config.Rules = append(config.Rules, *rule)
+			config.Problems = append(config.Problems, problems...)
+		case xml.Comment:
+			comments = append(comments, uncommentXML(t))
+		case xml.EndElement:
+			// </dispatch-entries>
+			return config, nil
+		}
+	}
+}
+
+func (l *XMLConfigLoader) parseEntry(decoder *xmlDecoder, line int) (*rawConfigRule, ValidationErrors, error) {
+	rule := &rawConfigRule{Line: line}
+	var problems ValidationErrors
+	var comments []string
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		line := decoder.line()
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			var field *string
+			switch t.Name.Local {
+			case "url":
+				field = &rule.URL
+			case "module":
+				field = &rule.ServiceName
+			default:
+				problems = append(problems, &ValidationError{Line: line, Message: fmt.Sprintf("unknown element: <%s>", t.Name.Local)})
+				if err := decoder.Skip(); err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+
+			if err := decoder.DecodeElement(field, &t); err != nil {
+				return nil, nil, err
+			}
+			*field = strings.TrimSpace(*field)
+		case xml.Comment:
+			comments = append(comments, uncommentXML(t))
+		case xml.EndElement:
+			// </dispatch>
+			rule.Comment = joinComments(comments...)
+			return rule, problems, nil
 		}
 	}
-	return &Config{Rules: rules}, nil
+}
+
+func uncommentXML(comment xml.Comment) string {
+	lines := strings.Split(strings.TrimSpace(string(comment)), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.Join(lines, "\n")
 }
@@ -1,6 +1,10 @@
 package gaedispemu
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
 
 func TestXMLConfigLoader(t *testing.T) {
 	loader := NewXMLConfigLoader("./testdata/dispatch.xml")
@@ -28,3 +32,27 @@ func TestXMLConfigLoaderError(t *testing.T) {
 		t.Error("should be error")
 	}
 }
+
+func TestXMLConfigLoaderValidate(t *testing.T) {
+	err := NewXMLConfigLoader("./testdata/dispatch.xml").ValidateConfig()
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = NewXMLConfigLoader("./testdata/problematic-dispatch.xml").ValidateConfig()
+	expected := ValidationErrors{
+		{Line: 9, Message: "Invalid URL pattern: */mob*ile/* (Invalid Path Pattern)"},
+		{Line: 14, Message: `duplicated rule: "*/favicon.ico" is already defined at line 4`},
+		{Line: 19, Message: "service is required"},
+		{Line: 21, Message: "unknown element: <service>"},
+		{Line: 24, Message: "url pattern is too long: 102 characters (up to 100 characters)"},
+	}
+	if diff := cmp.Diff(expected, err); diff != "" {
+		t.Errorf("unexpected validation errors: %s", diff)
+	}
+
+	err = NewXMLConfigLoader("./testdata/dispatch.yaml").ValidateConfig()
+	if _, ok := err.(ValidationErrors); ok || err == nil {
+		t.Errorf("should be parse error but got: %v", err)
+	}
+}
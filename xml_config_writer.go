@@ -0,0 +1,64 @@
+package gaedispemu
+
+import (
+	"bufio"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// XMLConfigWriter is a config writer for dispatch.xml
+type XMLConfigWriter struct{}
+
+var _ ConfigWriter = (*XMLConfigWriter)(nil)
+
+// NewXMLConfigWriter is constructor of XMLConfigWriter
+func NewXMLConfigWriter() *XMLConfigWriter {
+	return &XMLConfigWriter{}
+}
+
+// WriteConfig writes the config as dispatch.xml
+func (w *XMLConfigWriter) WriteConfig(out io.Writer, config *Config) error {
+	bw := bufio.NewWriter(out)
+	bw.WriteString(xml.Header)
+	writeXMLComment(bw, "", config.Comment)
+	bw.WriteString("<dispatch-entries>\n")
+	for _, rule := range config.Rules {
+		m, err := rule.patternMatcher()
+		if err != nil {
+			return err
+		}
+
+		bw.WriteString("  <dispatch>\n")
+		writeXMLComment(bw, "    ", rule.Comment)
+		writeXMLElement(bw, "    ", "url", m.String())
+		writeXMLElement(bw, "    ", "module", rule.ServiceName)
+		bw.WriteString("  </dispatch>\n")
+	}
+	bw.WriteString("</dispatch-entries>\n")
+	return bw.Flush()
+}
+
+func writeXMLElement(w *bufio.Writer, indent, name, value string) {
+	w.WriteString(indent + "<" + name + ">")
+	xml.EscapeText(w, []byte(value))
+	w.WriteString("</" + name + ">\n")
+}
+
+// writeXMLComment writes each line of the comment as <!-- -->
+func writeXMLComment(w *bufio.Writer, indent, comment string) {
+	if comment == "" {
+		return
+	}
+
+	for _, line := range strings.Split(comment, "\n") {
+		// "--" is not allowed in XML comments
+		for strings.Contains(line, "--") {
+			line = strings.Replace(line, "--", "- -", -1)
+		}
+		if strings.HasSuffix(line, "-") {
+			line += " "
+		}
+		w.WriteString(indent + "<!-- " + line + " -->\n")
+	}
+}
@@ -0,0 +1,44 @@
+package gaedispemu
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestXMLConfigWriter(t *testing.T) {
+	config := &Config{
+		Comment: "head comment\ncontains -- and <tags>",
+		Rules: []ConfigRule{
+			{ServiceName: "default", HostPathMatcher: mustCompileHostPathMatcher("*/favicon.ico"), Comment: "favicon"},
+			{ServiceName: "a&b", HostPathMatcher: mustCompileHostPathMatcher("*/mobile/*")},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := NewXMLConfigWriter().WriteConfig(&buf, config); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := heredoc.Doc(`
+		<?xml version="1.0" encoding="UTF-8"?>
+		<!-- head comment -->
+		<!-- contains - - and <tags> -->
+		<dispatch-entries>
+		  <dispatch>
+		    <!-- favicon -->
+		    <url>*/favicon.ico</url>
+		    <module>default</module>
+		  </dispatch>
+		  <dispatch>
+		    <url>*/mobile/*</url>
+		    <module>a&amp;b</module>
+		  </dispatch>
+		</dispatch-entries>
+	`)
+	if diff := cmp.Diff(expected, buf.String()); diff != "" {
+		t.Errorf("unexpected XML: %s", diff)
+	}
+}
@@ -1,25 +1,23 @@
 package gaedispemu
 
 import (
+	"fmt"
 	"os"
+	"strings"
 
-	yaml "gopkg.in/yaml.v2"
+	yaml "gopkg.in/yaml.v3"
 )
 
-type dispatchYAML struct {
-	Entries []dispatchEntryYAML `yaml:"dispatch"`
-}
-
-type dispatchEntryYAML struct {
-	URL         string `yaml:"url"`
-	ServiceName string `yaml:"service"`
-}
-
 // YAMLConfigLoader is a config loader for dispatch.yaml
 type YAMLConfigLoader struct {
 	filePath string
 }
 
+var (
+	_ ConfigLoader    = (*YAMLConfigLoader)(nil)
+	_ ConfigValidator = (*YAMLConfigLoader)(nil)
+)
+
 // NewYAMLConfigLoader is constructor of YAMLConfigLoader
 func NewYAMLConfigLoader(filePath string) *YAMLConfigLoader {
 	return &YAMLConfigLoader{filePath: filePath}
@@ -27,6 +25,25 @@ func NewYAMLConfigLoader(filePath string) *YAMLConfigLoader {
 
 // LoadConfig loads and parse the dispatch.yaml
 func (l *YAMLConfigLoader) LoadConfig() (*Config, error) {
+	raw, err := l.loadRawConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return raw.compile()
+}
+
+// ValidateConfig loads and validate the dispatch.yaml
+func (l *YAMLConfigLoader) ValidateConfig() error {
+	raw, err := l.loadRawConfig()
+	if err != nil {
+		return err
+	}
+
+	return raw.validate()
+}
+
+func (l *YAMLConfigLoader) loadRawConfig() (*rawConfig, error) {
 	f, err := os.Open(l.filePath)
 	if err != nil {
 		return nil, err
@@ -35,27 +52,99 @@ func (l *YAMLConfigLoader) LoadConfig() (*Config, error) {
 
 	decoder := yaml.NewDecoder(f)
 
-	var v dispatchYAML
-	err = decoder.Decode(&v)
+	var document yaml.Node
+	err = decoder.Decode(&document)
 	if err != nil {
 		return nil, err
 	}
 
-	return l.transform(&v)
+	return l.parse(&document)
+}
+
+func (l *YAMLConfigLoader) parse(document *yaml.Node) (*rawConfig, error) {
+	root := document
+	if root.Kind == yaml.DocumentNode {
+		if len(root.Content) == 0 {
+			return &rawConfig{}, nil
+		}
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("yaml: line %d: dispatch.yaml should be a mapping", root.Line)
+	}
+
+	config := &rawConfig{Comment: uncommentYAML(document.HeadComment, root.HeadComment)}
+	for i := 0; i < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		config.Comment = joinComments(config.Comment, uncommentYAML(key.HeadComment))
+		if key.Value != "dispatch" {
+			config.Problems = append(config.Problems, &ValidationError{Line: key.Line, Message: fmt.Sprintf("unknown key: %s", key.Value)})
+			continue
+		}
+		if value.Kind == yaml.ScalarNode && value.Tag == "!!null" {
+			continue
+		}
+		if value.Kind != yaml.SequenceNode {
+			return nil, fmt.Errorf("yaml: line %d: dispatch should be a sequence", value.Line)
+		}
+
+		for _, entry := range value.Content {
+			rule, problems, err := l.parseEntry(entry)
+			if err != nil {
+				return nil, err
+			}
+
+			config.Rules = append(config.Rules, *rule)
+			config.Problems = append(config.Problems, problems...)
+		}
+	}
+
+	return config, nil
 }
 
-func (l *YAMLConfigLoader) transform(rawConfifg *dispatchYAML) (*Config, error) {
-	rules := make([]ConfigRule, len(rawConfifg.Entries))
-	for i, entry := range rawConfifg.Entries {
-		hostPathMatcher, err := CompileHostPathMatcher(entry.URL)
-		if err != nil {
-			return nil, err
+func (l *YAMLConfigLoader) parseEntry(entry *yaml.Node) (*rawConfigRule, ValidationErrors, error) {
+	if entry.Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("yaml: line %d: dispatch rule should be a mapping", entry.Line)
+	}
+
+	rule := &rawConfigRule{Line: entry.Line, Comment: uncommentYAML(entry.HeadComment)}
+	var problems ValidationErrors
+	for i := 0; i < len(entry.Content); i += 2 {
+		key, value := entry.Content[i], entry.Content[i+1]
+		rule.Comment = joinComments(rule.Comment, uncommentYAML(key.HeadComment, key.LineComment, value.LineComment))
+
+		var field *string
+		switch key.Value {
+		case "url":
+			field = &rule.URL
+		case "service":
+			field = &rule.ServiceName
+		default:
+			problems = append(problems, &ValidationError{Line: key.Line, Message: fmt.Sprintf("unknown key: %s", key.Value)})
+			continue
+		}
+
+		if err := value.Decode(field); err != nil {
+			return nil, nil, err
 		}
+	}
+
+	return rule, problems, nil
+}
+
+// uncommentYAML strips "#" from the comments
+func uncommentYAML(comments ...string) string {
+	var lines []string
+	for _, comment := range comments {
+		for _, line := range strings.Split(comment, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
 
-		rules[i] = ConfigRule{
-			ServiceName:     entry.ServiceName,
-			HostPathMatcher: hostPathMatcher,
+			line = strings.TrimPrefix(line, "#")
+			lines = append(lines, strings.TrimPrefix(line, " "))
 		}
 	}
-	return &Config{Rules: rules}, nil
+	return strings.Join(lines, "\n")
 }
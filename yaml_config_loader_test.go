@@ -1,6 +1,10 @@
 package gaedispemu
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
 
 func TestYAMLConfigLoader(t *testing.T) {
 	loader := NewYAMLConfigLoader("./testdata/dispatch.yaml")
@@ -28,3 +32,27 @@ func TestYAMLConfigLoaderError(t *testing.T) {
 		t.Error("should be error")
 	}
 }
+
+func TestYAMLConfigLoaderValidate(t *testing.T) {
+	err := NewYAMLConfigLoader("./testdata/dispatch.yaml").ValidateConfig()
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = NewYAMLConfigLoader("./testdata/problematic-dispatch.yaml").ValidateConfig()
+	expected := ValidationErrors{
+		{Line: 7, Message: "Invalid URL pattern: */mob*ile/* (Invalid Path Pattern)"},
+		{Line: 11, Message: `duplicated rule: "*/favicon.ico" is already defined at line 3`},
+		{Line: 15, Message: "service is required"},
+		{Line: 16, Message: "unknown key: module"},
+		{Line: 19, Message: "url pattern is too long: 102 characters (up to 100 characters)"},
+	}
+	if diff := cmp.Diff(expected, err); diff != "" {
+		t.Errorf("unexpected validation errors: %s", diff)
+	}
+
+	err = NewYAMLConfigLoader("./testdata/dispatch.xml").ValidateConfig()
+	if _, ok := err.(ValidationErrors); ok || err == nil {
+		t.Errorf("should be parse error but got: %v", err)
+	}
+}
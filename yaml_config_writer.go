@@ -0,0 +1,71 @@
+package gaedispemu
+
+import (
+	"io"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// YAMLConfigWriter is a config writer for dispatch.yaml
+type YAMLConfigWriter struct{}
+
+var _ ConfigWriter = (*YAMLConfigWriter)(nil)
+
+// NewYAMLConfigWriter is constructor of YAMLConfigWriter
+func NewYAMLConfigWriter() *YAMLConfigWriter {
+	return &YAMLConfigWriter{}
+}
+
+// WriteConfig writes the config as dispatch.yaml
+func (w *YAMLConfigWriter) WriteConfig(out io.Writer, config *Config) error {
+	rules := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, rule := range config.Rules {
+		m, err := rule.patternMatcher()
+		if err != nil {
+			return err
+		}
+
+		rules.Content = append(rules.Content, &yaml.Node{
+			Kind:        yaml.MappingNode,
+			HeadComment: commentYAML(rule.Comment),
+			Content: []*yaml.Node{
+				{Kind: yaml.ScalarNode, Value: "url"},
+				{Kind: yaml.ScalarNode, Value: m.String(), Style: yaml.DoubleQuotedStyle},
+				{Kind: yaml.ScalarNode, Value: "service"},
+				{Kind: yaml.ScalarNode, Value: rule.ServiceName},
+			},
+		})
+	}
+
+	document := &yaml.Node{
+		Kind: yaml.DocumentNode,
+		Content: []*yaml.Node{{
+			Kind: yaml.MappingNode,
+			Content: []*yaml.Node{
+				{Kind: yaml.ScalarNode, Value: "dispatch", HeadComment: commentYAML(config.Comment)},
+				rules,
+			},
+		}},
+	}
+
+	encoder := yaml.NewEncoder(out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(document); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// commentYAML prepends "#" to each line of the comment
+func commentYAML(comment string) string {
+	if comment == "" {
+		return ""
+	}
+
+	lines := strings.Split(comment, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace("# " + line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,39 @@
+package gaedispemu
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestYAMLConfigWriter(t *testing.T) {
+	config := &Config{
+		Comment: "head comment",
+		Rules: []ConfigRule{
+			{ServiceName: "default", HostPathMatcher: mustCompileHostPathMatcher("*/favicon.ico"), Comment: "favicon\n\nicon"},
+			{ServiceName: "mobile-frontend", HostPathMatcher: mustCompileHostPathMatcher("*/mobile/*")},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := NewYAMLConfigWriter().WriteConfig(&buf, config); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := heredoc.Doc(`
+		# head comment
+		dispatch:
+		  # favicon
+		  #
+		  # icon
+		  - url: "*/favicon.ico"
+		    service: default
+		  - url: "*/mobile/*"
+		    service: mobile-frontend
+	`)
+	if diff := cmp.Diff(expected, buf.String()); diff != "" {
+		t.Errorf("unexpected YAML: %s", diff)
+	}
+}